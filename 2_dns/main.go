@@ -19,7 +19,10 @@ import (
 const (
 	kPlugFileListPath = "./config/plugin.json"
 	//kPlugFileListPath = "/go/src/app/2_dns/config/plugin.json"
-	kPluginTickerIntervalMillisecond = 1000
+	kPluginTickerIntervalMillisecond  = 1000
+	kSelfRegisterAttempts             = 10
+	kSelfRegisterFirstWaitMillisecond = 100
+	kSelfRegisterMaxWaitMillisecond   = 10000
 )
 
 type pluginListJson struct {
@@ -40,9 +43,14 @@ type PluginHttpServerInterface interface {
 	SetDataGet(v func([]byte) (error, int, []communsTypes.KeyValueType))
 	SetDataPut(v func(communsTypes.KeyValueType) error)
 	SetDataDelete(v func([]byte) error)
+	SetDataGetByPrefix(v func([]byte) (error, int, []communsTypes.KeyValueType))
+	SetDataTransaction(v func([]communsTypes.KeyValueType, [][]byte) error)
 	SelfRegister() error
 	Register(name, target string, port int) error
 	GetServiceKeyPrefix() string
+	SetStatus(name string, v func() error)
+	SetCounters(name string, v func() map[string]uint64)
+	Changed(events []communsTypes.KeyValueType)
 }
 
 type PluginDataInterface interface {
@@ -56,10 +64,11 @@ type PluginDataInterface interface {
 	SetOnWatch(watchFunc func([]communsTypes.KeyValueType, []communsTypes.KeyValueType))
 	Watch(key []byte)
 	Delete(key []byte) error
+	Transaction(put []communsTypes.KeyValueType, del [][]byte) error
 }
 
 type PluginOnLoadInterface interface {
-	OnLoad(...interface{})
+	OnLoad(...interface{}) error
 }
 
 type PluginDnsInterface interface {
@@ -72,7 +81,13 @@ type PluginDnsInterface interface {
 	RemoveRegisterFromServiceByName(serviceName string, v dns.Record)
 	RemoveServiceByName(serviceName string)
 	Connect() error
-	Test() error
+	Probe() error
+	Ready() error
+	Live() error
+	GetCounters() map[string]uint64
+	SetDataGet(v func([]byte) (error, int, []communsTypes.KeyValueType))
+	SetDataTransaction(v func([]communsTypes.KeyValueType, [][]byte) error)
+	SetServiceKeyPrefix(prefix string)
 }
 
 func openPluginHttpServer(path string, conf interface{}) PluginHttpServerInterface {
@@ -107,15 +122,43 @@ func openPluginHttpServer(path string, conf interface{}) PluginHttpServerInterfa
 	pluginHttpServerLoaded.SetDataGet(pluginData.Get)
 	pluginHttpServerLoaded.SetDataPut(pluginData.Put)
 	pluginHttpServerLoaded.SetDataDelete(pluginData.Delete)
+	pluginHttpServerLoaded.SetDataGetByPrefix(pluginData.GetByPrefix)
+	pluginHttpServerLoaded.SetDataTransaction(pluginData.Transaction)
 
-	go pluginHttpServerLoaded.Connect()
-	time.Sleep(time.Millisecond * 333)
+	go func() {
+		err := pluginHttpServerLoaded.Connect()
+		if err != nil {
+			log.Printf("pluginHttpServer.Connect().Error: %v\n", err.Error())
+		}
+	}()
 
-	pluginHttpServerLoaded.SelfRegister()
+	go retryWithBackoff("pluginHttpServer.SelfRegister()", pluginHttpServerLoaded.SelfRegister)
 
 	return pluginHttpServerLoaded
 }
 
+// calls of the http server plugin, retried with exponential backoff while the listener starts
+func retryWithBackoff(name string, call func() error) {
+	var err error
+	var wait = time.Millisecond * kSelfRegisterFirstWaitMillisecond
+
+	for attempt := 1; attempt <= kSelfRegisterAttempts; attempt++ {
+		time.Sleep(wait)
+
+		err = call()
+		if err == nil {
+			return
+		}
+
+		log.Printf("%v.Error: attempt %v of %v: %v\n", name, attempt, kSelfRegisterAttempts, err.Error())
+
+		wait *= 2
+		if wait > time.Millisecond*kSelfRegisterMaxWaitMillisecond {
+			wait = time.Millisecond * kSelfRegisterMaxWaitMillisecond
+		}
+	}
+}
+
 func openPluginDns(path string, conf interface{}) PluginDnsInterface {
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
@@ -146,7 +189,10 @@ func openPluginDns(path string, conf interface{}) PluginDnsInterface {
 	go pluginData.Connect()
 	time.Sleep(time.Millisecond * 333)
 
-	pluginData.Test()
+	err = pluginData.Probe()
+	if err != nil {
+		log.Printf("pluginDns.Probe().Error: %v\n", err.Error())
+	}
 
 	return pluginData
 }
@@ -208,7 +254,10 @@ func openPluginOnLoad(path string, conf interface{}) PluginOnLoadInterface {
 		os.Exit(1)
 	}
 
-	pluginOnLoad.OnLoad(conf)
+	err = pluginOnLoad.OnLoad(conf)
+	if err != nil {
+		log.Printf("pluginOnLoad.OnLoad().Error: %v\n", err.Error())
+	}
 
 	return pluginOnLoad
 }
@@ -268,14 +317,14 @@ func onDnsBeforeOnChange(v func(string, map[string]map[dns.Type][]dns.Record)) {
 // maquina de casa
 //go:generate /home/hkemper/Downloads/golang_binaries/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/hkemper/Dropbox/gRPC/2_dns/plugin/dataPlugin/etcd/etcd.so /home/hkemper/Dropbox/gRPC/2_dns/plugin/dataPlugin/etcd/etcd.go
 //go:generate /home/hkemper/Downloads/golang_binaries/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/hkemper/Dropbox/gRPC/2_dns/plugin/onLoad/setEnvironmentVarByJson.so /home/hkemper/Dropbox/gRPC/2_dns/plugin/onLoad/setEnvironmentVarByJson.go
-//go:generate /home/hkemper/Downloads/golang_binaries/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/dns/benBurkertDns.so /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/dns/benBurkertDns.go
-//go:generate /home/hkemper/Downloads/golang_binaries/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/httpServer/benBurkertDnsCompatibleHttpServer.so /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/httpServer/benBurkertDnsCompatibleHttpServer.go
+//go:generate /home/hkemper/Downloads/golang_binaries/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/dns/benBurkertDns.so /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/dns
+//go:generate /home/hkemper/Downloads/golang_binaries/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/httpServer/benBurkertDnsCompatibleHttpServer.so /home/hkemper/Dropbox/gRPC/2_dns/plugin/serviceDiscover/httpServer
 
 // maquina da empresa
 //go:generate /home/kemper/Programas/Golang/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/dataPlugin/etcd/etcd.so /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/dataPlugin/etcd/etcd.go
 //go:generate /home/kemper/Programas/Golang/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/onLoad/setEnvironmentVarByJson.so /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/onLoad/setEnvironmentVarByJson.go
-//go:generate /home/kemper/Programas/Golang/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/dns/benBurkertDns.so /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/dns/benBurkertDns.go
-//go:generate /home/kemper/Programas/Golang/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/httpServer/benBurkertDnsCompatibleHttpServer.so /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/httpServer/benBurkertDnsCompatibleHttpServer.go
+//go:generate /home/kemper/Programas/Golang/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/dns/benBurkertDns.so /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/dns
+//go:generate /home/kemper/Programas/Golang/go1.11.4/bin/go build -buildmode=plugin -installsuffix=shared -gcflags=-shared -installsuffix=dynlink -gcflags=-dynlink -o /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/httpServer/benBurkertDnsCompatibleHttpServer.so /home/kemper/Projetos/ahgora/gRPC/2_dns/plugin/serviceDiscover/httpServer
 
 func main() {
 	var wg sync.WaitGroup
@@ -376,14 +425,29 @@ func tickerFuncToReloadPlugin(ticker *time.Ticker) {
 
 		if pluginData != nil && pluginDns != nil {
 			prefix := pluginHttpServer.GetServiceKeyPrefix()
+
+			// dynamic updates are saved by the data plugin, under the keys of the http server plugin
+			pluginDns.SetDataGet(pluginData.Get)
+			pluginDns.SetDataTransaction(pluginData.Transaction)
+			pluginDns.SetServiceKeyPrefix(prefix)
+		}
+
+		if pluginData != nil && pluginHttpServer != nil {
+			prefix := pluginHttpServer.GetServiceKeyPrefix()
+
 			pluginData.Watch([]byte(prefix))
 			pluginData.SetOnWatch(func(new []communsTypes.KeyValueType, old []communsTypes.KeyValueType) {
-				found := len(new)
+				// clients of the watch stream of the http server
+				pluginHttpServer.Changed(new)
+
+				if pluginDns == nil {
+					return
+				}
 
 				for k := range new {
 
 					keyToFind := strings.Replace(string(new[k].K), prefix, "", 1)
-					if found == 0 {
+					if string(new[k].T) == "DELETE" {
 						pluginDns.RemoveServiceByName(keyToFind)
 					} else {
 						pluginDns.SetServiceBySRV(keyToFind, new[k].V)
@@ -407,10 +471,14 @@ func tickerFuncToReloadPlugin(ticker *time.Ticker) {
 				log.Fatal(err)
 			}
 
-			err = pluginHttpServer.Register("dns.service.discover", "", int(port))
-			if err != nil {
-				log.Fatal(err)
-			}
+			pluginHttpServer.SetStatus("dns.ready", pluginDns.Ready)
+			pluginHttpServer.SetStatus("dns.live", pluginDns.Live)
+			pluginHttpServer.SetCounters("dns.counters", pluginDns.GetCounters)
+
+			pluginHttpServerLoaded := pluginHttpServer
+			go retryWithBackoff("pluginHttpServer.Register()", func() error {
+				return pluginHttpServerLoaded.Register("dns.service.discover", "", int(port))
+			})
 		}
 
 		if pluginData != nil && pluginHttpServer != nil && pluginDns != nil {
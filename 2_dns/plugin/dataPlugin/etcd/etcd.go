@@ -4,11 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"gRPC/2_dns/plugin/pluginConfig"
 	"github.com/coreos/etcd/clientv3"
 	"github.com/helmutkemper/communsTypesForGolangPlugin"
-	"io/ioutil"
 	"log"
-	"os"
 	"runtime"
 	"strings"
 	"time"
@@ -34,6 +33,7 @@ type PluginDataInterface interface {
 	SetOnWatch(watchFunc func([]communsTypes.KeyValueType, []communsTypes.KeyValueType))
 	Watch(key []byte)
 	Delete(key []byte) error
+	Transaction(put []communsTypes.KeyValueType, del [][]byte) error
 }
 
 func (el *Etcd) handleError(err error) {
@@ -43,8 +43,17 @@ func (el *Etcd) handleError(err error) {
 	}
 }
 
+// config of the plugin. Each key can be set by an environment variable, ex.: ETCD_HOST_LIST, see pluginConfig
+type configJSon struct {
+	HostList       string
+	KeyPrefix      string
+	DialTimeOut    float64
+	RequestTimeOut float64
+}
+
 // on plugin load function
 // conf[0] - string containing a json file path of configuration file
+// conf[1] - optional config object, replaced by the keys of the file and by the environment variables
 //
 //   json example:
 //   {
@@ -53,18 +62,14 @@ func (el *Etcd) handleError(err error) {
 //     "dialTimeOut": 500000
 //     "requestTimeOut": 1000000
 //   }
+//
+//   environment variables: ETCD_HOST_LIST, ETCD_KEY_PREFIX, ETCD_DIAL_TIME_OUT and ETCD_REQUEST_TIME_OUT
 func (el *Etcd) OnLoad(conf ...interface{}) error {
 	var err error
 	var fileContent []byte
-	var jsonData map[string]interface{}
-	var filePath = conf[0].([]interface{})[0].(string)
-
-	if _, err = os.Stat(filePath); os.IsNotExist(err) {
-		el.handleError(err)
-		return err
-	}
+	var jsonData configJSon
 
-	fileContent, err = ioutil.ReadFile(filePath)
+	fileContent, err = pluginConfig.Load("ETCD", conf[0].([]interface{}), configJSon{})
 	if err != nil {
 		el.handleError(err)
 		return err
@@ -76,35 +81,33 @@ func (el *Etcd) OnLoad(conf ...interface{}) error {
 		return err
 	}
 
-	el.hostList = jsonData["hostList"].(string)
+	el.hostList = jsonData.HostList
 	if el.hostList == "" {
 		err = errors.New("json hostList key not found")
 		el.handleError(err)
 		return err
 	}
 
-	el.keyPrefix = jsonData["keyPrefix"].(string)
+	el.keyPrefix = jsonData.KeyPrefix
 	if el.keyPrefix == "" {
 		err = errors.New("json keyPrefix key not found")
 		el.handleError(err)
 		return err
 	}
 
-	tm := jsonData["dialTimeOut"].(float64)
-	if tm == 0 {
+	if jsonData.DialTimeOut == 0 {
 		err = errors.New("json dialTimeOut key not found")
 		el.handleError(err)
 		return err
 	}
-	el.dialTimeOut = time.Duration(tm) * time.Microsecond
+	el.dialTimeOut = time.Duration(jsonData.DialTimeOut) * time.Microsecond
 
-	tm = jsonData["requestTimeOut"].(float64)
-	if tm == 0 {
+	if jsonData.RequestTimeOut == 0 {
 		err = errors.New("json requestTimeOut key not found")
 		el.handleError(err)
 		return err
 	}
-	el.requestTimeOut = time.Duration(tm) * time.Microsecond
+	el.requestTimeOut = time.Duration(jsonData.RequestTimeOut) * time.Microsecond
 
 	return nil
 }
@@ -266,6 +269,12 @@ func (el *Etcd) watch(key string) {
 			for eventKey := range watchResp.Events {
 				newValue[eventKey].T = []byte(watchResp.Events[eventKey].Type.String())
 				newValue[eventKey].K = watchResp.Events[eventKey].Kv.Key
+
+				// deleted keys have no value
+				if watchResp.Events[eventKey].Type == clientv3.EventTypeDelete {
+					continue
+				}
+
 				err = json.Unmarshal(watchResp.Events[eventKey].Kv.Value, &newValue[eventKey])
 				if err != nil {
 					el.handleError(err)
@@ -296,4 +305,36 @@ func (el *Etcd) Delete(key []byte) error {
 	return err
 }
 
+// puts and deletes the keys in one etcd transaction, every change is applied or none is.
+// A key must be in the transaction only once
+func (el *Etcd) Transaction(put []communsTypes.KeyValueType, del [][]byte) error {
+	var err error
+	var jsonData []byte
+	var ops = make([]clientv3.Op, 0, len(put)+len(del))
+
+	for k := range put {
+		jsonData, err = json.Marshal(&put[k])
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+
+		ops = append(ops, clientv3.OpPut(string(put[k].K), string(jsonData)))
+	}
+
+	for _, key := range del {
+		ops = append(ops, clientv3.OpDelete(string(key)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), el.requestTimeOut)
+	_, err = el.cli.Txn(ctx).Then(ops...).Commit()
+	cancel()
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
+
+	return nil
+}
+
 var PluginData Etcd
@@ -1,45 +1,121 @@
 package main
 
 import (
-	"encoding/json"
-	"github.com/emicklei/go-restful/log"
-	"io/ioutil"
+	"errors"
+	"log"
 	"os"
+	"sort"
+	"strings"
 )
 
-type OnLoad struct{}
+type OnLoad struct {
+	// variables set by the plugin, replaced when the host loads the plugin again
+	setByPlugin map[string]bool
+}
 
 type PluginOnLoadInterface interface {
-	OnLoad(...interface{})
+	OnLoad(...interface{}) error
 }
 
-//fixme: return error
-func (el *OnLoad) OnLoad(conf ...interface{}) {
+// plugin on load function
+// this is a first function to run after plugin loaded. It sets the environment variables of the files, in order, so
+// the variables of a file replace the variables of the files before it. Variables of the process environment, ex.: set
+// by docker compose, are kept, so they replace the files, as the plugins read their config from plugin.json, then from
+// the file and then from the environment
+// conf[0] - list of file paths: .json, .yml or .yaml files, or env files of KEY=VALUE lines
+//
+//   json file example:
+//   {
+//     "DNS_ADDRESS_AND_PORT": ":53535",
+//     "ETCD": {
+//       "HOST_LIST": ["172.18.0.1:2379", "172.18.0.2:2379"],
+//       "KEY_PREFIX": "dnsServerKey"
+//     },
+//     "ETCD_PASSWORD_SECRET_FILE": "/run/secrets/etcd",
+//     "REST_TLS_CERT_FILE": "/etc/dns/cert.pem",
+//     "REST_URL": "http://${HOSTNAME:-localhost}:${REST_PORT}"
+//   }
+//
+//   Nested keys are joined by '_' and upper cased, ex.: ETCD_HOST_LIST, and lists are joined by ','.
+//   ${VAR} is replaced by a variable of the environment or of the files, ${VAR:-default} by the default when VAR is
+//   blank or not set.
+//   VAR_SECRET_FILE, of the files or of the environment, sets VAR to the content of the file, like the Docker secrets.
+//   Other keys ending in _FILE, ex.: REST_TLS_CERT_FILE, are set as they are.
+func (el *OnLoad) OnLoad(conf ...interface{}) error {
 	var err error
-	var fileContent []byte
-	var jsonData map[string]interface{}
+	var vars = make(map[string]string)
+	var keys []string
+
+	filePathList, ok := conf[0].([]interface{})
+	if !ok || len(filePathList) == 0 {
+		return errors.New("plugin onLoad() config error. please, send a list of file paths")
+	}
+
+	for _, filePath := range filePathList {
+		var source map[string]string
+
+		path, ok := filePath.(string)
+		if !ok {
+			return errors.New("plugin onLoad() config error. file paths must be strings")
+		}
 
-	if _, err = os.Stat(conf[0].([]interface{})[0].(string)); os.IsNotExist(err) {
-		return
+		source, err = readSource(path)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range source {
+			vars[key] = value
+		}
 	}
 
-	fileContent, err = ioutil.ReadFile(conf[0].([]interface{})[0].(string))
+	if el.setByPlugin == nil {
+		el.setByPlugin = make(map[string]bool)
+	}
 
-	err = json.Unmarshal(fileContent, &jsonData)
+	vars, err = resolve(vars, el.environment())
 	if err != nil {
-		log.Printf("plugin onLoad() json error: %v\n", err.Error())
-		return
+		return err
 	}
 
-	for key, value := range jsonData {
-		err = os.Setenv(key, value.(string))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, ok := os.LookupEnv(key); ok && !el.setByPlugin[key] {
+			log.Printf("[set environment var plugin log] %v kept from the environment\n", key)
+			continue
+		}
+
+		el.setByPlugin[key] = true
+		err = os.Setenv(key, vars[key])
 		if err != nil {
-			log.Printf("plugin onLoad() > os.Setenv(%v, %v).error: %v\n", key, value, err.Error())
-			return
+			return err
 		}
 
-		log.Printf("plugin onLoad() > os.Setenv(%v, %v)\n", key, value)
+		// values aren't logged, they may be secrets
+		log.Printf("[set environment var plugin log] os.Setenv(%v)\n", key)
 	}
+
+	return nil
+}
+
+// variables of the process environment, without the variables set by the plugin
+func (el *OnLoad) environment() map[string]string {
+	var environment = make(map[string]string)
+
+	for _, variable := range os.Environ() {
+		separator := strings.Index(variable, "=")
+		if separator <= 0 || el.setByPlugin[variable[:separator]] {
+			continue
+		}
+
+		environment[variable[:separator]] = variable[separator+1:]
+	}
+
+	return environment
 }
 
 var PluginOnLoad OnLoad
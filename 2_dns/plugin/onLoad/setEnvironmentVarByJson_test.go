@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"gRPC/2_dns/plugin/pluginConfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// config keys of the http server and dns plugins ending in _FILE
+type testRestConfig struct {
+	Tls *struct {
+		CertFile string
+	}
+	Auth *struct {
+		PolicyFile string
+	}
+}
+
+type testDnsConfig struct {
+	QueryLog *struct {
+		File string
+	}
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "onLoad")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func testWrite(t *testing.T, filePath, content string) {
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sets the variables and returns a function to remove them
+func testSetenv(t *testing.T, vars map[string]string) func() {
+	for key, value := range vars {
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return func() {
+		for key := range vars {
+			_ = os.Unsetenv(key)
+		}
+	}
+}
+
+func testOnLoad(t *testing.T, content string) (*OnLoad, func()) {
+	var onLoad = &OnLoad{}
+
+	dir := testDir(t)
+	testWrite(t, filepath.Join(dir, "setenv.env"), content)
+
+	err := onLoad.OnLoad([]interface{}{filepath.Join(dir, "setenv.env")})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return onLoad, func() {
+		for key := range onLoad.setByPlugin {
+			_ = os.Unsetenv(key)
+		}
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func testPluginConfig(t *testing.T, prefix string, config interface{}) {
+	fileContent, err := pluginConfig.Load(prefix, []interface{}{"./notFound.json"}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = json.Unmarshal(fileContent, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the config paths of the plugins set by the setenv files reach the config of the plugins, also when the file doesn't
+// exist yet, as the query log
+func TestOnLoadPluginConfigFilePaths(t *testing.T) {
+	var rest testRestConfig
+	var dnsConfig testDnsConfig
+
+	_, done := testOnLoad(t, `
+REST_TLS_CERT_FILE=/etc/dns/cert.pem
+REST_AUTH_POLICY_FILE=/etc/dns/policy.json
+DNS_QUERY_LOG_FILE=/var/log/dns/notFound/query.log
+`)
+	defer done()
+
+	testPluginConfig(t, "REST", &rest)
+	testPluginConfig(t, "DNS", &dnsConfig)
+
+	if rest.Tls == nil || rest.Tls.CertFile != "/etc/dns/cert.pem" {
+		t.Errorf("tls.certFile: expected /etc/dns/cert.pem, got %+v", rest.Tls)
+	}
+
+	if rest.Auth == nil || rest.Auth.PolicyFile != "/etc/dns/policy.json" {
+		t.Errorf("auth.policyFile: expected /etc/dns/policy.json, got %+v", rest.Auth)
+	}
+
+	if dnsConfig.QueryLog == nil || dnsConfig.QueryLog.File != "/var/log/dns/notFound/query.log" {
+		t.Errorf("queryLog.file: expected /var/log/dns/notFound/query.log, got %+v", dnsConfig.QueryLog)
+	}
+
+	if _, ok := os.LookupEnv("REST_TLS_CERT"); ok {
+		t.Error("REST_TLS_CERT must not be set")
+	}
+}
+
+// the secret files of the setenv files and of the environment are read
+func TestOnLoadSecretFile(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	testWrite(t, filepath.Join(dir, "etcd"), "etcdPassword\n")
+	testWrite(t, filepath.Join(dir, "db"), "dbPassword\n")
+
+	defer testSetenv(t, map[string]string{"TEST_DB_PASSWORD_SECRET_FILE": filepath.Join(dir, "db")})()
+
+	_, done := testOnLoad(t, "TEST_ETCD_PASSWORD_SECRET_FILE="+filepath.Join(dir, "etcd")+"\n")
+	defer done()
+
+	if value := os.Getenv("TEST_ETCD_PASSWORD"); value != "etcdPassword" {
+		t.Errorf("TEST_ETCD_PASSWORD: expected etcdPassword, got %q", value)
+	}
+
+	if value := os.Getenv("TEST_DB_PASSWORD"); value != "dbPassword" {
+		t.Errorf("TEST_DB_PASSWORD: expected dbPassword, got %q", value)
+	}
+
+	if _, ok := os.LookupEnv("TEST_ETCD_PASSWORD_SECRET_FILE"); ok {
+		t.Error("TEST_ETCD_PASSWORD_SECRET_FILE must not be set")
+	}
+}
+
+// the environment wins over the files, also in ${VAR}
+func TestOnLoadEnvironmentInterpolation(t *testing.T) {
+	defer testSetenv(t, map[string]string{"TEST_REST_PORT": "9090"})()
+
+	_, done := testOnLoad(t, `
+TEST_REST_PORT=8080
+TEST_REST_URL=http://localhost:${TEST_REST_PORT}
+`)
+	defer done()
+
+	if value := os.Getenv("TEST_REST_PORT"); value != "9090" {
+		t.Errorf("TEST_REST_PORT: expected 9090, got %q", value)
+	}
+
+	if value := os.Getenv("TEST_REST_URL"); value != "http://localhost:9090" {
+		t.Errorf("TEST_REST_URL: expected http://localhost:9090, got %q", value)
+	}
+}
+
+// the json and yaml files are flattened to A_B_C keys
+func TestReadSourceFlatten(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		file    string
+		content string
+		vars    map[string]string
+		err     string
+	}{
+		{
+			file:    "nested.json",
+			content: `{"etcd": {"host-list": ["172.18.0.1:2379", "172.18.0.2:2379"], "tls": {"enabled": true}}, "dns.port": 53535}`,
+			vars:    map[string]string{"ETCD_HOST_LIST": "172.18.0.1:2379,172.18.0.2:2379", "ETCD_TLS_ENABLED": "true", "DNS_PORT": "53535"},
+		},
+		{
+			file:    "nested.yaml",
+			content: "etcd:\n  host-list:\n    - 172.18.0.1:2379\n    - 172.18.0.2:2379\n  tls:\n    enabled: true\ndns:\n  port: 53535\n  address: \"\"\n",
+			vars:    map[string]string{"ETCD_HOST_LIST": "172.18.0.1:2379,172.18.0.2:2379", "ETCD_TLS_ENABLED": "true", "DNS_PORT": "53535", "DNS_ADDRESS": ""},
+		},
+		{
+			file:    "numbers.json",
+			content: `{"rateLimit": {"queriesPerSecond": 1.5, "burst": 100000000000}, "secret": null}`,
+			vars:    map[string]string{"RATELIMIT_QUERIESPERSECOND": "1.5", "RATELIMIT_BURST": "100000000000", "SECRET": ""},
+		},
+		{
+			file:    "collision.json",
+			content: `{"ETCD_HOST_LIST": "172.18.0.1:2379", "ETCD": {"HOST_LIST": "172.18.0.2:2379"}}`,
+			err:     "the key ETCD_HOST_LIST is set twice",
+		},
+		{
+			file:    "collision.yml",
+			content: "etcd-host: 172.18.0.1\netcd:\n  host: 172.18.0.2\n",
+			err:     "the key ETCD_HOST is set twice",
+		},
+		{
+			file:    "nestedList.json",
+			content: `{"hosts": [{"name": "a"}]}`,
+			err:     "the list HOSTS must have values only",
+		},
+		{
+			file:    "list.json",
+			content: `["DNS_PORT"]`,
+			err:     "the file must be an object of variables",
+		},
+	}
+
+	for _, test := range tests {
+		filePath := filepath.Join(dir, test.file)
+		testWrite(t, filePath, test.content)
+
+		vars, err := readSource(filePath)
+		if test.err != "" {
+			if err == nil || err.Error() != filePath+": "+test.err {
+				t.Errorf("%v: expected the error %q, got %v", test.file, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", test.file, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(vars, test.vars) {
+			t.Errorf("%v: expected %v, got %v", test.file, test.vars, vars)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// suffix of the keys read from a file, like the Docker secrets: DB_PASSWORD_SECRET_FILE=/run/secrets/db sets
+// DB_PASSWORD. It isn't "_FILE", the config keys of the plugins ending in _FILE, ex.: REST_TLS_CERT_FILE, are paths
+const kSecretFileKeySuffix = "_SECRET_FILE"
+
+// ${VAR} or ${VAR:-default}
+var interpolation = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// variables of the file, by the extension: .json, .yml and .yaml are nested maps flattened to A_B_C keys, every other
+// file is an env file of KEY=VALUE lines
+func readSource(filePath string) (map[string]string, error) {
+	var err error
+	var fileContent []byte
+	var data interface{}
+	var vars = make(map[string]string)
+
+	fileContent, err = ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(fileContent))
+		decoder.UseNumber()
+
+		err = decoder.Decode(&data)
+
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(fileContent, &data)
+
+	default:
+		vars, err = readEnvFile(fileContent)
+		if err != nil {
+			return nil, errors.New(filePath + ": " + err.Error())
+		}
+
+		return vars, nil
+	}
+
+	if err != nil {
+		return nil, errors.New(filePath + ": " + err.Error())
+	}
+
+	if _, ok := data.(map[string]interface{}); !ok {
+		if _, ok = data.(map[interface{}]interface{}); !ok {
+			return nil, errors.New(filePath + ": the file must be an object of variables")
+		}
+	}
+
+	err = flatten("", data, vars)
+	if err != nil {
+		return nil, errors.New(filePath + ": " + err.Error())
+	}
+
+	return vars, nil
+}
+
+// env file format
+//
+//   # comment
+//   DNS_PORT=53535
+//   export ETCD_HOST_LIST="172.18.0.1:2379,172.18.0.2:2379"
+//   ETCD_PASSWORD_SECRET_FILE=/run/secrets/etcd
+func readEnvFile(fileContent []byte) (map[string]string, error) {
+	var vars = make(map[string]string)
+	var lineNumber int
+
+	scanner := bufio.NewScanner(bytes.NewReader(fileContent))
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		separator := strings.Index(line, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("line %v: expected KEY=VALUE", lineNumber)
+		}
+
+		key := strings.TrimSpace(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		vars[key] = value
+	}
+
+	return vars, scanner.Err()
+}
+
+// keys of the nested maps joined by '_' and upper cased, ex.: {"etcd": {"host-list": "..."}} sets ETCD_HOST_LIST.
+// Lists of values are joined by ','. Two keys of the file with the same variable, ex.: ETCD_HOST_LIST and
+// {"etcd": {"host_list": "..."}}, are an error
+func flatten(prefix string, data interface{}, vars map[string]string) error {
+	var err error
+
+	switch converted := data.(type) {
+	case map[string]interface{}:
+		var keys = make([]string, 0, len(converted))
+
+		for key := range converted {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			err = flatten(flattenKey(prefix, key), converted[key], vars)
+			if err != nil {
+				return err
+			}
+		}
+
+	// yaml maps
+	case map[interface{}]interface{}:
+		var stringMap = make(map[string]interface{}, len(converted))
+
+		for key, value := range converted {
+			if _, ok := stringMap[fmt.Sprint(key)]; ok {
+				return errors.New("the key " + flattenKey(prefix, fmt.Sprint(key)) + " is set twice")
+			}
+
+			stringMap[fmt.Sprint(key)] = value
+		}
+
+		return flatten(prefix, stringMap, vars)
+
+	case []interface{}:
+		var list = make([]string, 0, len(converted))
+
+		for _, value := range converted {
+			switch value.(type) {
+			case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+				return errors.New("the list " + prefix + " must have values only")
+			}
+
+			list = append(list, scalar(value))
+		}
+
+		return setFlattened(vars, prefix, strings.Join(list, ","))
+
+	default:
+		return setFlattened(vars, prefix, scalar(converted))
+	}
+
+	return nil
+}
+
+func setFlattened(vars map[string]string, key, value string) error {
+	if _, ok := vars[key]; ok {
+		return errors.New("the key " + key + " is set twice")
+	}
+
+	vars[key] = value
+	return nil
+}
+
+func flattenKey(prefix, key string) string {
+	key = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "_" + key
+}
+
+func scalar(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// values of the variables, with the ${VAR} interpolated and the KEY_SECRET_FILE variables replaced by the content of
+// the file. The environment replaces the variables of the files, also in ${VAR}, and its values aren't interpolated
+func resolve(vars, environment map[string]string) (map[string]string, error) {
+	var err error
+	var keys = make([]string, 0, len(vars))
+	var resolved = make(map[string]string)
+	var resolving = make(map[string]bool)
+
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var lookup func(key string) (string, bool, error)
+	lookup = func(key string) (string, bool, error) {
+		if value, ok := environment[key]; ok {
+			return value, true, nil
+		}
+
+		if value, ok := resolved[key]; ok {
+			return value, true, nil
+		}
+
+		value, ok := vars[key]
+		if !ok {
+			return "", false, nil
+		}
+
+		if resolving[key] {
+			return "", false, errors.New("variable " + key + " is in a loop of ${VAR} references")
+		}
+		resolving[key] = true
+
+		var lookupErr error
+		value = interpolation.ReplaceAllStringFunc(value, func(match string) string {
+			parts := interpolation.FindStringSubmatch(match)
+
+			found, ok, err := lookup(parts[1])
+			if err != nil {
+				lookupErr = err
+				return ""
+			}
+
+			if !ok || (found == "" && parts[2] != "") {
+				if parts[2] == "" {
+					lookupErr = errors.New("variable " + key + " refers to " + parts[1] + ", that is not set")
+				}
+
+				return parts[3]
+			}
+
+			return found
+		})
+		if lookupErr != nil {
+			return "", false, lookupErr
+		}
+
+		resolved[key] = value
+		return value, true, nil
+	}
+
+	for _, key := range keys {
+		_, _, err = lookup(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, readSecretFiles(resolved, vars, environment)
+}
+
+// replaces the KEY_SECRET_FILE variables of the files and of the environment by KEY, with the content of the file. The
+// environment wins over the files: a KEY of the environment is kept and a KEY_SECRET_FILE of the environment replaces
+// the KEY of the files
+func readSecretFiles(resolved, vars, environment map[string]string) error {
+	var keys []string
+
+	for key := range vars {
+		keys = append(keys, key)
+	}
+
+	for key := range environment {
+		if _, ok := vars[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !strings.HasSuffix(key, kSecretFileKeySuffix) || key == kSecretFileKeySuffix {
+			continue
+		}
+
+		filePath, fromEnvironment := environment[key]
+		if !fromEnvironment {
+			filePath = resolved[key]
+			delete(resolved, key)
+		}
+
+		name := strings.TrimSuffix(key, kSecretFileKeySuffix)
+		if _, ok := environment[name]; ok {
+			if fromEnvironment {
+				return errors.New("variables " + name + " and " + key + " are both set in the environment. please, set one of them")
+			}
+
+			continue
+		}
+
+		if _, ok := vars[name]; ok && !fromEnvironment {
+			return errors.New("variables " + name + " and " + key + " are both set. please, set one of them")
+		}
+
+		fileContent, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return errors.New("variable " + key + ": " + err.Error())
+		}
+
+		resolved[name] = strings.TrimRight(string(fileContent), "\r\n")
+	}
+
+	return nil
+}
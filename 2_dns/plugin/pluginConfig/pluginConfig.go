@@ -0,0 +1,266 @@
+// Configuration of the built-in plugins, merged from three sources. Each source replaces the keys of the one before it
+//
+//   1. plugin.json: the object after the file path in conf, ex.: "conf": ["./config/etcd.json", {"keyPrefix": "dns"}]
+//   2. file:        the json file in conf[0]. The file is optional, a missing file is skipped
+//   3. environment: one variable for each key of the config struct of the plugin, named by the prefix of the plugin and
+//                   the key in upper case, ex.: hostList of the etcd plugin is ETCD_HOST_LIST and tls.certFile of the
+//                   http server plugin is REST_TLS_CERT_FILE. Blank variables are ignored
+//
+//   Lists of strings are read from the environment as comma separated values, ex.: REST_TRUSTED_PROXIES=10.0.0.0/8,fd00::/8.
+//   Other lists and maps are read as json, ex.: REST_REGISTER=[{"schema":"http","endpoint":"service","name":"http"}]
+//
+//   fileContent, err := pluginConfig.Load("ETCD", conf[0].([]interface{}), configJSon{})
+package pluginConfig
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// json config of the plugin, merged from the conf of plugin.json, the file in conf[0] and the environment variables of
+// the keys of the schemas, the config structs of the plugin
+func Load(prefix string, conf []interface{}, schemas ...interface{}) ([]byte, error) {
+	var config = make(map[string]interface{})
+
+	if len(conf) == 0 {
+		return nil, errors.New("plugin conf not found. please, send the config file path")
+	}
+
+	filePath, ok := conf[0].(string)
+	if !ok {
+		return nil, errors.New("plugin conf error. the first value must be the config file path")
+	}
+
+	if len(conf) > 1 {
+		inline, ok := conf[1].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("plugin conf error. the second value must be a config object")
+		}
+
+		merge(config, inline)
+	}
+
+	fileContent, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		var fileConfig map[string]interface{}
+
+		err = json.Unmarshal(fileContent, &fileConfig)
+		if err != nil {
+			return nil, errors.New(filePath + ": " + err.Error())
+		}
+
+		merge(config, fileConfig)
+	}
+
+	for _, schema := range schemas {
+		err = fromEnvironment(config, prefix, reflect.TypeOf(schema))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(config)
+}
+
+// calls onField for each field of the struct that isn't a struct, with the environment variable name and the json keys
+func walk(prefix string, structType reflect.Type, onField func(name string, path []string, fieldType reflect.Type)) {
+	var visit func(prefix string, path []string, structType reflect.Type)
+
+	visit = func(prefix string, path []string, structType reflect.Type) {
+		for structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			key := jsonKey(field)
+			if key == "-" {
+				continue
+			}
+
+			name := prefix + "_" + envName(field.Name)
+			fieldPath := append(append([]string{}, path...), key)
+
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+
+			if fieldType.Kind() == reflect.Struct {
+				visit(name, fieldPath, fieldType)
+				continue
+			}
+
+			onField(name, fieldPath, fieldType)
+		}
+	}
+
+	visit(prefix, nil, structType)
+}
+
+func fromEnvironment(config map[string]interface{}, prefix string, schema reflect.Type) error {
+	var err error
+
+	walk(prefix, schema, func(name string, path []string, fieldType reflect.Type) {
+		var value interface{}
+
+		text := os.Getenv(name)
+		if text == "" || err != nil {
+			return
+		}
+
+		value, err = parse(text, fieldType)
+		if err != nil {
+			err = errors.New("environment variable " + name + ": " + err.Error())
+			return
+		}
+
+		set(config, path, value)
+	})
+
+	return err
+}
+
+// json value of the environment variable, by the type of the field
+func parse(text string, fieldType reflect.Type) (interface{}, error) {
+	var value interface{}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return text, nil
+
+	case reflect.Bool:
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, errors.New("must be true or false")
+		}
+
+		return value, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, errors.New("must be a number")
+		}
+
+		return value, nil
+
+	case reflect.Slice:
+		if fieldType.Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(text), "[") {
+			var list []interface{}
+
+			for _, item := range strings.Split(text, ",") {
+				list = append(list, strings.TrimSpace(item))
+			}
+
+			return list, nil
+		}
+	}
+
+	err := json.Unmarshal([]byte(text), &value)
+	return value, err
+}
+
+// sets the value in the keys of the path, creating the objects of the path. Keys are matched like encoding/json does,
+// without case, so the file and the environment never set two keys of the same field
+func set(config map[string]interface{}, path []string, value interface{}) {
+	for i, key := range path {
+		key = findKey(config, key)
+
+		if i == len(path)-1 {
+			config[key] = value
+			return
+		}
+
+		next, ok := config[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			config[key] = next
+		}
+
+		config = next
+	}
+}
+
+// objects are merged key by key, other values are replaced
+func merge(config, source map[string]interface{}) {
+	for key, value := range source {
+		key = findKey(config, key)
+
+		sourceObject, isObject := value.(map[string]interface{})
+		configObject, wasObject := config[key].(map[string]interface{})
+		if isObject && wasObject {
+			merge(configObject, sourceObject)
+			continue
+		}
+
+		config[key] = value
+	}
+}
+
+// key of the config equal to the key without case, or the key when it isn't in the config
+func findKey(config map[string]interface{}, key string) string {
+	for configKey := range config {
+		if strings.EqualFold(configKey, key) {
+			return configKey
+		}
+	}
+
+	return key
+}
+
+func jsonKey(field reflect.StructField) string {
+	if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+
+	runes := []rune(field.Name)
+	runes[0] = unicode.ToLower(runes[0])
+
+	return string(runes)
+}
+
+// upper case name of the field, with '_' between the words, ex.: HostList is HOST_LIST and IPv4PrefixLength is
+// IPV4_PREFIX_LENGTH
+func envName(fieldName string) string {
+	var name []rune
+	var runes = []rune(fieldName)
+	var upperRun int
+
+	for i, char := range runes {
+		if i > 0 && unicode.IsUpper(char) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (upperRun >= 2 && nextIsLower) {
+				name = append(name, '_')
+			}
+		}
+
+		if unicode.IsUpper(char) {
+			upperRun++
+		} else {
+			upperRun = 0
+		}
+
+		name = append(name, unicode.ToUpper(char))
+	}
+
+	return string(name)
+}
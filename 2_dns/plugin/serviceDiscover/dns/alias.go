@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/helmutkemper/dns"
+	"strings"
+)
+
+// longest CNAME chain followed inside the zone
+const kZoneMaxAliasHops = 8
+
+// data format saved by the http server plugin for an alias, under the key of a service
+type serviceAlias struct {
+	Alias string
+}
+
+// CNAME record of the json saved for an alias. Service names are made fully qualified in the zone, names ended in
+// point are used as they are
+func aliasRecord(zone *serviceZone, JSon []byte) (*dns.CNAME, bool) {
+	var saved serviceAlias
+
+	// services are saved as a list and fail here
+	if json.Unmarshal(JSon, &saved) != nil || saved.Alias == "" {
+		return nil, false
+	}
+
+	if strings.HasSuffix(saved.Alias, ".") {
+		return &dns.CNAME{CNAME: saved.Alias}, true
+	}
+
+	return &dns.CNAME{CNAME: zone.Fqdn(saved.Alias)}, true
+}
@@ -18,13 +18,12 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"gRPC/2_dns/plugin/pluginConfig"
+	"github.com/helmutkemper/communsTypesForGolangPlugin"
 	"github.com/helmutkemper/dns"
 	"github.com/pkg/errors"
-	"io/ioutil"
 	"log"
 	"net"
-	"os"
 	"runtime"
 	"strconv"
 	"time"
@@ -34,6 +33,39 @@ type Dns struct {
 	addressAndPort string
 	serialNumber   int
 	server         *dns.Server
+	zone           *serviceZone
+	reverse        *reverseZone
+	healthCheck    *healthChecker
+	queryLog       *queryLogger
+	rateLimit      *rateLimiter
+	transfer       *zoneTransfer
+	update         *dynamicUpdate
+	dnssec         *dnssecSigner
+	splitHorizon   *splitHorizon
+	probe          *readinessProbe
+	edns           ednsConfig
+	storage        serviceStorage
+}
+
+// optional sections of the configuration file
+type configJSon struct {
+	HealthCheck   *healthCheckConfig
+	QueryLog      *queryLogConfig
+	RateLimit     *rateLimitConfig
+	ZoneTransfer  *zoneTransferConfig
+	DynamicUpdate *dynamicUpdateConfig
+	Dnssec        *dnssecConfig
+	SplitHorizon  *splitHorizonConfig
+	Probe         *probeConfig
+	Edns          *ednsConfig
+
+	NegativeTtlSecond int
+}
+
+// keys of the configuration file read by name in OnLoad(), for the environment variables of pluginConfig
+type configJSonListener struct {
+	AddressAndPort string
+	SerialNumber   string
 }
 
 type PluginDnsInterface interface {
@@ -46,7 +78,13 @@ type PluginDnsInterface interface {
 	RemoveRegisterFromServiceByName(serviceName string, v dns.Record)
 	RemoveServiceByName(serviceName string)
 	Connect() error
-	Test() error
+	Probe() error
+	Ready() error
+	Live() error
+	GetCounters() map[string]uint64
+	SetDataGet(v func([]byte) (error, int, []communsTypes.KeyValueType))
+	SetDataTransaction(v func([]communsTypes.KeyValueType, [][]byte) error)
+	SetServiceKeyPrefix(prefix string)
 }
 
 func (el *Dns) handleError(err error) {
@@ -56,27 +94,113 @@ func (el *Dns) handleError(err error) {
 	}
 }
 
+// shows the log identifying the plugin generator information
+func (el *Dns) log(info string) {
+	log.Printf("[Ben Burkert DNS plugin log] %v", info)
+}
+
 // on plugin load function
 // conf[0] - string containing a json file path of configuration file
+// conf[1] - optional config object, replaced by the keys of the file and by the environment variables
 //
 //   json example:
 //   {
 //     "addressAndPort": ":53",
-//     "serialNumber": 1234
+//     "serialNumber": 1234,
+//     "negativeTtlSecond": 60,
+//     "healthCheck": {
+//       "intervalMillisecond": 5000,
+//       "timeOutMillisecond": 1000,
+//       "failureThreshold": 3,
+//       "successThreshold": 1,
+//       "services": {
+//         "node": { "type": "tcp" },
+//         "api": { "type": "http", "path": "/health" },
+//         "helloworld": { "type": "grpc", "service": "helloworld.Greeter" }
+//       }
+//     },
+//     "queryLog": {
+//       "file": "/var/log/dns/query.log",
+//       "maxSizeMegaByte": 100,
+//       "maxBackups": 5,
+//       "dnstap": "unix:/var/run/dnstap.sock",
+//       "identity": "dns.service.discover"
+//     },
+//     "rateLimit": {
+//       "queriesPerSecond": 50,
+//       "queriesBurst": 100,
+//       "responsesPerSecond": 5,
+//       "responsesBurst": 10,
+//       "slip": 2,
+//       "exempt": ["127.0.0.0/8"]
+//     },
+//     "zoneTransfer": {
+//       "allow": ["10.0.0.0/8"],
+//       "notify": ["10.0.0.2:53"]
+//     },
+//     "dynamicUpdate": {
+//       "tsigKeys": {
+//         "update.key.": { "algorithm": "hmac-sha256", "secret": "c2VjcmV0IG9mIHRoZSB1cGRhdGUga2V5" }
+//       },
+//       "allow": ["10.0.0.0/8"],
+//       "fudgeSecond": 300
+//     },
+//     "dnssec": {
+//       "keys": ["/etc/dns/Ktld.+013+12345", "/etc/dns/Ktld.+013+54321"],
+//       "denial": "nsec3",
+//       "nsec3Iterations": 0,
+//       "nsec3Salt": "",
+//       "signatureValidityHour": 168
+//     },
+//     "splitHorizon": {
+//       "views": [
+//         { "name": "internal", "networks": ["10.0.0.0/8", "172.16.0.0/12"] },
+//         { "name": "external", "networks": ["0.0.0.0/0", "::/0"] }
+//       ],
+//       "ednsClientSubnet": true
+//     },
+//     "probe": {
+//       "name": "_canary",
+//       "intervalMillisecond": 10000,
+//       "timeOutMillisecond": 1000
+//     },
+//     "edns": {
+//       "udpPayloadSize": 1232,
+//       "maxAnswers": 16
+//     }
 //   }
+//
+//   Each key can be set by an environment variable with the DNS prefix, see pluginConfig, ex.: DNS_ADDRESS_AND_PORT,
+//   DNS_RATE_LIMIT_QUERIES_PER_SECOND or DNS_SPLIT_HORIZON_VIEWS='[{"name": "internal", "networks": ["10.0.0.0/8"]}]'.
+//   negativeTtlSecond is optional, default 60. It is the minimum field of the SOA and the TTL of the NXDOMAIN and
+//   NODATA answers, so clients ask again soon for services that aren't registered yet.
+//   healthCheck is optional. Only the SRV targets of the services listed in it are checked and only healthy targets
+//   are served.
+//   queryLog is optional. Every query and answer is written to the file and/or to the dnstap destination.
+//   rateLimit is optional. It limits the udp queries of each source ip and the identical responses sent to each client
+//   network.
+//   zoneTransfer is optional. It allows AXFR/IXFR from the secondary servers and sends NOTIFY to them when the records
+//   change. serialNumber is incremented on each change.
+//   dynamicUpdate is optional. It accepts RFC 2136 UPDATE messages over udp, signed with one of the TSIG keys, to add
+//   and delete SRV records. The records are saved by the data plugin, as the http server plugin does.
+//   dnssec is optional. It signs the answers of the service zone online, with NSEC or NSEC3 denial of existence, for
+//   queries with the DO flag. Changes in the records drop the old signatures. It can't be used with healthCheck or
+//   splitHorizon, as the denial of existence is made from the unfiltered records.
+//   splitHorizon is optional. Instances registered with a view are only served to the clients of the view, found by
+//   the source address or by the EDNS Client Subnet option of udp queries.
+//   probe is optional, the values above are the defaults. The readiness probe queries addressAndPort for names below
+//   the canary name, answered by the plugin without any record in the zone.
+//   edns is optional. Udp responses larger than the EDNS0 buffer size of the client, or 512 bytes for clients without
+//   EDNS0, are sent with the TC flag, so the client retries over tcp. maxAnswers limits the SRV, A and other records of
+//   each answer to a random subset, so big services still resolve over udp. It can't be used with dnssec.
 func (el *Dns) OnLoad(conf ...interface{}) error {
 	var err error
 	var fileContent []byte
 	var jsonData map[string]interface{}
-	var filePath = conf[0].([]interface{})[0].(string)
+	var jsonConfig configJSon
 	var serialNumber int64
 
-	if _, err = os.Stat(filePath); os.IsNotExist(err) {
-		el.handleError(err)
-		return err
-	}
-
-	fileContent, err = ioutil.ReadFile(filePath)
+	fileContent, err = pluginConfig.Load("DNS", conf[0].([]interface{}), configJSonListener{}, configJSon{})
 	if err != nil {
 		el.handleError(err)
 		return err
@@ -88,7 +212,7 @@ func (el *Dns) OnLoad(conf ...interface{}) error {
 		return err
 	}
 
-	el.addressAndPort = jsonData["addressAndPort"].(string)
+	el.addressAndPort, _ = jsonData["addressAndPort"].(string)
 	if el.addressAndPort == "" {
 		err = errors.New("json addressAndPort key not found")
 		el.handleError(err)
@@ -98,6 +222,8 @@ func (el *Dns) OnLoad(conf ...interface{}) error {
 	switch converted := jsonData["serialNumber"].(type) {
 	case int:
 		el.serialNumber = converted
+	case float64:
+		el.serialNumber = int(converted)
 	case string:
 		serialNumber, err = strconv.ParseInt(converted, 10, 64)
 		if err != nil {
@@ -107,12 +233,163 @@ func (el *Dns) OnLoad(conf ...interface{}) error {
 		el.serialNumber = int(serialNumber)
 	}
 
+	err = json.Unmarshal(fileContent, &jsonConfig)
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
+
+	soa := &dns.SOA{
+		NS:     "dns.tld.",
+		MBox:   "hostmaster.tld.",
+		Serial: el.serialNumber,
+		MinTTL: kZoneDefaultNegativeTtlSecond * time.Second,
+	}
+
+	if jsonConfig.NegativeTtlSecond < 0 {
+		err = errors.New("json negativeTtlSecond must be a positive number of seconds")
+		el.handleError(err)
+		return err
+	} else if jsonConfig.NegativeTtlSecond != 0 {
+		soa.MinTTL = time.Duration(jsonConfig.NegativeTtlSecond) * time.Second
+	}
+
+	el.zone = newServiceZone("tld.", time.Hour, soa)
+	el.reverse = newReverseZone(time.Hour, el.zone.SOA)
+	el.zone.OnChange(func() {
+		el.zone.IncrementSerial()
+		el.reverse.Build(el.zone)
+	})
+
+	el.healthCheck = nil
+	if jsonConfig.HealthCheck != nil {
+		el.healthCheck, err = newHealthChecker(*jsonConfig.HealthCheck, el.zone, el.handleError, el.log)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+
+		el.zone.AddFilter(el.healthCheck.IsHealthy)
+	}
+
+	el.queryLog = nil
+	if jsonConfig.QueryLog != nil {
+		el.queryLog, err = newQueryLogger(*jsonConfig.QueryLog, el.handleError)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+	}
+
+	el.rateLimit = nil
+	if jsonConfig.RateLimit != nil {
+		el.rateLimit, err = newRateLimiter(*jsonConfig.RateLimit)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+	}
+
+	el.transfer = nil
+	if jsonConfig.ZoneTransfer != nil {
+		el.transfer, err = newZoneTransfer(*jsonConfig.ZoneTransfer, el.zone, el.reverse, el.handleError, el.log)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+
+		el.zone.OnChange(el.transfer.Changed)
+	}
+
+	if jsonConfig.Probe == nil {
+		jsonConfig.Probe = &probeConfig{}
+	}
+
+	el.probe, err = newReadinessProbe(*jsonConfig.Probe, el.zone.Origin(), el.addressAndPort, el.log)
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
+
+	el.splitHorizon = nil
+	if jsonConfig.SplitHorizon != nil {
+		el.splitHorizon, err = newSplitHorizon(*jsonConfig.SplitHorizon)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+
+		el.zone.AddFilter(el.splitHorizon.IsVisible)
+	}
+
+	if jsonConfig.Edns == nil {
+		jsonConfig.Edns = &ednsConfig{}
+	}
+
+	el.edns, err = newEdnsConfig(*jsonConfig.Edns)
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
+
+	// a subset of the records doesn't match the signature of the entire record set
+	if el.edns.MaxAnswers != 0 && jsonConfig.Dnssec != nil {
+		err = errors.New("json edns.maxAnswers can't be used with dnssec")
+		el.handleError(err)
+		return err
+	}
+
+	// the NSEC/NSEC3 chain is made from all the records of the zone, so names hidden by the filters would be proved to
+	// exist, or the NODATA proof of a filtered answer would be wrong
+	if jsonConfig.Dnssec != nil && (jsonConfig.HealthCheck != nil || jsonConfig.SplitHorizon != nil) {
+		err = errors.New("json dnssec can't be used with healthCheck or splitHorizon")
+		el.handleError(err)
+		return err
+	}
+
+	el.zone.SetMaxAnswers(el.edns.MaxAnswers)
+
+	el.dnssec = nil
+	if jsonConfig.Dnssec != nil {
+		el.dnssec, err = newDnssecSigner(*jsonConfig.Dnssec, el.zone, el.handleError)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+
+		el.zone.OnChange(el.dnssec.Changed)
+	}
+
+	el.update = nil
+	if jsonConfig.DynamicUpdate != nil {
+		el.update, err = newDynamicUpdate(*jsonConfig.DynamicUpdate, el.zone, &el.storage, el.handleError, el.log)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+	}
+
 	return nil
 }
 
+// plugin set dataGet from external plugin data function
+func (el *Dns) SetDataGet(v func([]byte) (error, int, []communsTypes.KeyValueType)) {
+	el.storage.get = v
+}
+
+// plugin set dataTransaction from external plugin data function, used to save each dynamic update as one change
+func (el *Dns) SetDataTransaction(v func([]communsTypes.KeyValueType, [][]byte) error) {
+	el.storage.transaction = v
+}
+
+// prefix of the keys of the services in the data plugin, the same used by the http server plugin
+func (el *Dns) SetServiceKeyPrefix(prefix string) {
+	el.storage.keyPrefix = prefix
+}
+
 // set entire DNS records list
 func (el *Dns) Set(serviceList map[string]map[dns.Type][]dns.Record) {
-	el.server.Set(serviceList)
+	el.zone.Set(serviceList)
 }
 
 func (el *Dns) GetAddressAndPort() string {
@@ -121,11 +398,26 @@ func (el *Dns) GetAddressAndPort() string {
 
 // set records for service name
 func (el *Dns) SetServiceByName(serviceName string, v map[dns.Type][]dns.Record) {
-	el.server.SetKey(serviceName, v)
+	el.zone.SetKey(serviceName, v)
 }
 
+// set SRV records for service name from the json saved by the http server plugin. Instances with metadata are also
+// served as TXT records and instances with a view are only served to the clients of the view. Aliases are served as
+// CNAME records
 func (el *Dns) SetServiceBySRV(serviceName string, JSon []byte) {
-	var records []dns.SRV
+	var records []serviceRecord
+
+	el.storage.setName(serviceName)
+
+	if cname, ok := aliasRecord(el.zone, JSon); ok {
+		if el.splitHorizon != nil {
+			el.splitHorizon.RemoveService(serviceName)
+		}
+
+		el.zone.SetKey(serviceName, map[dns.Type][]dns.Record{dns.TypeCNAME: {cname}})
+		return
+	}
+
 	err := json.Unmarshal(JSon, &records)
 	if err != nil {
 		log.Printf("ganbiarra dns json error: %v\n", err)
@@ -144,24 +436,38 @@ func (el *Dns) SetServiceBySRV(serviceName string, JSon []byte) {
 		avoidsProblemsWithPointers.Weight = v.Weight
 
 		toSet[dns.TypeSRV][k] = &avoidsProblemsWithPointers
+
+		if len(v.Metadata) != 0 {
+			toSet[dns.TypeTXT] = append(toSet[dns.TypeTXT], instanceTXT(&avoidsProblemsWithPointers, v.Metadata))
+		}
 	}
 
-	el.server.SetKey(serviceName, toSet)
+	if el.splitHorizon != nil {
+		el.splitHorizon.SetService(serviceName, records)
+	}
+
+	el.zone.SetKey(serviceName, toSet)
 }
 
 // set or append records for service name
 func (el *Dns) AppendNewRegisterInServiceByName(serviceName string, v dns.Record) {
-	el.server.AppendRecordInKey(serviceName, v)
+	el.zone.AppendRecordInKey(serviceName, v)
 }
 
 // remove register from service by service name
 func (el *Dns) RemoveRegisterFromServiceByName(serviceName string, v dns.Record) {
-	el.server.DeleteRecordInKey(serviceName, v)
+	el.zone.DeleteRecordInKey(serviceName, v)
 }
 
 // remove service key from records list
 func (el *Dns) RemoveServiceByName(serviceName string) {
-	el.server.DeleteKey(serviceName)
+	el.storage.removeName(serviceName)
+
+	if el.splitHorizon != nil {
+		el.splitHorizon.RemoveService(serviceName)
+	}
+
+	el.zone.DeleteKey(serviceName)
 }
 
 // start DNS service
@@ -174,46 +480,155 @@ func (el *Dns) Connect() error {
 		return err
 	}
 
+	if el.healthCheck != nil {
+		go el.healthCheck.Run()
+	}
+
+	if el.transfer != nil {
+		go el.transfer.Run()
+	}
+
+	go el.probe.Run()
+
 	//fixme: ssl
 	el.server = &dns.Server{
-		Addr: el.addressAndPort,
-		Handler: &dns.Zone{
-			Origin: "tld.",
-			TTL:    time.Hour,
-			SOA: &dns.SOA{
-				NS:     "dns.tld.",
-				MBox:   "hostmaster.tld.",
-				Serial: el.serialNumber,
-			},
-			RRs: dns.RRSet{},
-		},
+		Addr:    el.addressAndPort,
+		Handler: el,
 	}
 
-	return el.server.ListenAndServe(context.Background())
+	return el.listenAndServe(context.Background())
 }
 
-func (el *Dns) Test() error {
-	el.SetServiceByName("test.fake.service", map[dns.Type][]dns.Record{dns.TypeSRV: {&dns.SRV{Weight: 10, Priority: 0, Port: 8080, Target: "i.an.alive."}}})
+// start the udp and tcp listeners. Udp packets pass by the EDNS0 buffer size reader, the rate limiter, the dynamic
+// update and the client subnet reader before reaching the server. Tcp connections pass by the zone transfer
+func (el *Dns) listenAndServe(ctx context.Context) error {
+	var err error
+	var packetConn net.PacketConn
+	var listener net.Listener
+	var errChan = make(chan error, 2)
 
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{}
-			return d.DialContext(ctx, "udp", net.JoinHostPort("127.0.0.1", "53535"))
-		},
+	packetConn, err = net.ListenPacket("udp", el.addressAndPort)
+	if err != nil {
+		el.handleError(err)
+		el.probe.Serving(err)
+		return err
 	}
 
-	cname, srv, err := resolver.LookupSRV(context.Background(), "", "", "test.fake.service.tld.")
+	listener, err = net.Listen("tcp", el.addressAndPort)
 	if err != nil {
-		return errors.New("Ben Bukert DNS test fail. Could not get IPs: " + err.Error() + "\n")
+		el.handleError(err)
+		el.probe.Serving(err)
+		_ = packetConn.Close()
+		return err
 	}
-	for _, s := range srv {
-		fmt.Printf("%v: srv IN A Target: %s, Port: %v, Priority: %v, Weight: %v\n", cname, s.Target, s.Port, s.Priority, s.Weight)
+
+	if el.transfer != nil {
+		listener = &transferListener{Listener: listener, transfer: el.transfer}
 	}
 
-	el.RemoveServiceByName("test.fake.service")
+	packetConn = &ednsPacketConn{PacketConn: packetConn, udpPayloadSize: el.edns.UdpPayloadSize}
 
-	return nil
+	if el.rateLimit != nil {
+		packetConn = &rateLimitedPacketConn{PacketConn: packetConn, limiter: el.rateLimit}
+	}
+
+	if el.update != nil {
+		packetConn = &updatePacketConn{PacketConn: packetConn, update: el.update}
+	}
+
+	if el.splitHorizon != nil && el.splitHorizon.clientSubnet {
+		packetConn = &clientSubnetPacketConn{PacketConn: packetConn, udpPayloadSize: uint16(el.edns.UdpPayloadSize)}
+	}
+
+	el.probe.Serving(nil)
+
+	go func() {
+		errChan <- el.server.ServePacket(ctx, packetConn)
+	}()
+
+	go func() {
+		errChan <- el.server.Serve(ctx, listener)
+	}()
+
+	err = <-errChan
+	if err == nil {
+		err = errProbeNotServed
+	}
+	el.probe.Serving(err)
+
+	_ = packetConn.Close()
+	_ = listener.Close()
+
+	return err
+}
+
+// counters of the plugin, ex.: "rateLimit.queriesDropped"
+func (el *Dns) GetCounters() map[string]uint64 {
+	var counters = make(map[string]uint64)
+
+	if el.rateLimit != nil {
+		for key, value := range el.rateLimit.Counters() {
+			counters[key] = value
+		}
+	}
+
+	return counters
+}
+
+// dns.Handler interface
+func (el *Dns) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+	if el.queryLog != nil {
+		el.queryLog.ServeDNS(ctx, w, r, dns.HandlerFunc(el.serveZone))
+		return
+	}
+
+	el.serveZone(ctx, w, r)
+}
+
+// AXFR/IXFR queries are answered by the zone transfer, canary names by the readiness probe, in-addr.arpa. and ip6.arpa.
+// names by the reverse zone and everything else by the service zone, signed when dnssec is enabled
+func (el *Dns) serveZone(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+	if len(r.Questions) != 0 && isZoneTransfer(r.Questions[0]) {
+		if el.transfer == nil {
+			w.Status(dns.Refused)
+			return
+		}
+
+		el.transfer.ServeDNS(ctx, w, r)
+		return
+	}
+
+	if len(r.Questions) != 0 && el.probe.IsCanary(r.Questions[0].Name) {
+		el.probe.ServeDNS(ctx, w, r)
+		return
+	}
+
+	if len(r.Questions) != 0 && isReverseName(r.Questions[0].Name) {
+		el.reverse.ServeDNS(ctx, w, r)
+		return
+	}
+
+	if el.dnssec != nil && len(r.Questions) != 0 {
+		el.dnssec.ServeDNS(ctx, w, r, el.zone)
+		return
+	}
+
+	el.zone.ServeDNS(ctx, w, r)
+}
+
+// query the listener for the canary name now. nil when the plugin is ready
+func (el *Dns) Probe() error {
+	return el.probe.Probe()
+}
+
+// result of the last readiness probe. nil when the plugin is ready
+func (el *Dns) Ready() error {
+	return el.probe.Ready()
+}
+
+// state of the listener. nil while it is serving
+func (el *Dns) Live() error {
+	return el.probe.Live()
 }
 
 var PluginData Dns
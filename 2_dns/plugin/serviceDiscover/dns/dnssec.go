@@ -0,0 +1,592 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/helmutkemper/dns"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kDnssecDenialNsec  = "nsec"
+	kDnssecDenialNsec3 = "nsec3"
+
+	kDnssecDefaultSignatureValidityHour = 24 * 7
+	kDnssecInceptionOffset              = time.Hour
+	kDnssecMaxCachedSignatures          = 10000
+
+	kNsec3HashSha1 = 1
+
+	// DO flag of the EDNS0 OPT record, RFC 3225
+	kEdnsFlagDnssecOk = 0x8000
+)
+
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// dnssec configuration
+//
+//   keys:                  key files made by dnssec-keygen, without the extension. Keys with the SEP flag (KSK) sign
+//                          the DNSKEY records, the others (ZSK) sign everything else. A single KSK signs everything
+//   denial:                "nsec" or "nsec3". default "nsec"
+//   nsec3Iterations:       extra NSEC3 hash iterations. RFC 9276 recommends 0
+//   nsec3Salt:             NSEC3 salt in hex. RFC 9276 recommends no salt
+//   signatureValidityHour: validity of the signatures. default 168
+type dnssecConfig struct {
+	Keys                  []string
+	Denial                string
+	Nsec3Iterations       uint16
+	Nsec3Salt             string
+	SignatureValidityHour int
+}
+
+type cachedSignature struct {
+	rrsig []*RRSIG
+}
+
+// name of the zone with the types of records it has, one link of the NSEC/NSEC3 chain
+type dnssecNode struct {
+	name   string
+	hash   []byte
+	types  []dns.Type
+	record dns.Record
+	owner  string
+}
+
+// dnssecSigner signs the answers of the service zone online. Signatures are kept in a cache until they get old or the
+// records change, and the NSEC/NSEC3 chain is made again on each change of the zone.
+// Only the service zone is signed; zone transfers and the reverse zone are sent without signatures.
+type dnssecSigner struct {
+	sync.RWMutex
+	zone       *serviceZone
+	ksk        []*dnssecKey
+	zsk        []*dnssecKey
+	denial     string
+	nsec3Param *NSEC3PARAM
+	validity   time.Duration
+	cache      map[string]cachedSignature
+	chain      []dnssecNode
+	onError    func(error)
+}
+
+func newDnssecSigner(config dnssecConfig, zone *serviceZone, onError func(error)) (*dnssecSigner, error) {
+	var signer = &dnssecSigner{
+		zone:     zone,
+		denial:   strings.ToLower(config.Denial),
+		validity: time.Duration(config.SignatureValidityHour) * time.Hour,
+		cache:    make(map[string]cachedSignature),
+		onError:  onError,
+	}
+
+	if signer.validity == 0 {
+		signer.validity = kDnssecDefaultSignatureValidityHour * time.Hour
+	}
+
+	switch signer.denial {
+	case "", kDnssecDenialNsec:
+		signer.denial = kDnssecDenialNsec
+	case kDnssecDenialNsec3:
+		salt, err := hex.DecodeString(config.Nsec3Salt)
+		if err != nil || len(salt) > 255 {
+			return nil, errors.New("dnssec nsec3Salt must be hex, up to 255 bytes")
+		}
+		signer.nsec3Param = &NSEC3PARAM{Hash: kNsec3HashSha1, Iterations: config.Nsec3Iterations, Salt: salt}
+	default:
+		return nil, errors.New("dnssec denial must be nsec or nsec3")
+	}
+
+	for _, path := range config.Keys {
+		key, err := loadDnssecKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.isKSK() {
+			signer.ksk = append(signer.ksk, key)
+		} else {
+			signer.zsk = append(signer.zsk, key)
+		}
+	}
+
+	if len(signer.ksk) == 0 {
+		return nil, errors.New("dnssec needs at least one key with the SEP flag (KSK)")
+	}
+
+	// a single key signs everything
+	if len(signer.zsk) == 0 {
+		signer.zsk = signer.ksk
+	}
+
+	signer.Changed()
+
+	return signer, nil
+}
+
+// true if the query asked for DNSSEC records with the DO flag of the EDNS0 OPT record
+func dnssecOk(r *dns.Query) bool {
+	for _, resource := range r.Additionals {
+		if resource.Record != nil && resource.Record.Type() == dns.TypeOPT {
+			// the OPT TTL field carries the extended rcode, the version and the flags
+			return uint32(resource.TTL/time.Second)&kEdnsFlagDnssecOk != 0
+		}
+	}
+
+	return false
+}
+
+// changeListener of the service zone. Old signatures are dropped and the denial chain is made again
+func (el *dnssecSigner) Changed() {
+	var chain []dnssecNode
+	var origin = el.zone.Origin()
+	var exists = make(map[string]bool)
+
+	apexTypes := []dns.Type{dns.TypeSOA, kTypeRRSIG, kTypeDNSKEY}
+	if el.denial == kDnssecDenialNsec {
+		apexTypes = append(apexTypes, kTypeNSEC)
+	} else {
+		apexTypes = append(apexTypes, kTypeNSEC3PARAM)
+	}
+
+	for _, serviceName := range el.zone.Names() {
+		var types []dns.Type
+
+		for recordType, list := range el.zone.Get(serviceName) {
+			if len(list) != 0 {
+				types = append(types, recordType)
+			}
+		}
+
+		if len(types) == 0 {
+			continue
+		}
+
+		fqdn := el.zone.Fqdn(serviceName)
+		if fqdn == origin {
+			apexTypes = append(apexTypes, types...)
+			continue
+		}
+
+		types = append(types, kTypeRRSIG)
+		if el.denial == kDnssecDenialNsec {
+			types = append(types, kTypeNSEC)
+		}
+		chain = append(chain, dnssecNode{name: fqdn, types: types})
+		exists[fqdn] = true
+
+		// NSEC3 has records for the empty non-terminals too, RFC 5155 section 7.1
+		for parent := parentName(fqdn); el.denial == kDnssecDenialNsec3 && parent != origin && !exists[parent]; parent = parentName(parent) {
+			chain = append(chain, dnssecNode{name: parent})
+			exists[parent] = true
+		}
+	}
+
+	chain = append(chain, dnssecNode{name: origin, types: apexTypes})
+
+	if el.denial == kDnssecDenialNsec {
+		sort.Slice(chain, func(i, j int) bool { return canonicalLess(chain[i].name, chain[j].name) })
+
+		for k := range chain {
+			next := chain[(k+1)%len(chain)]
+			chain[k].owner = chain[k].name
+			chain[k].record = &NSEC{NextDomain: next.name, TypeBitMap: chain[k].types}
+		}
+	} else {
+		for k := range chain {
+			chain[k].hash = el.nsec3Hash(chain[k].name)
+			chain[k].owner = strings.ToLower(nsec3Encoding.EncodeToString(chain[k].hash)) + "." + origin
+		}
+
+		sort.Slice(chain, func(i, j int) bool { return bytes.Compare(chain[i].hash, chain[j].hash) < 0 })
+
+		for k := range chain {
+			next := chain[(k+1)%len(chain)]
+			chain[k].record = &NSEC3{
+				Hash:       el.nsec3Param.Hash,
+				Iterations: el.nsec3Param.Iterations,
+				Salt:       el.nsec3Param.Salt,
+				NextHashed: next.hash,
+				TypeBitMap: chain[k].types,
+			}
+		}
+	}
+
+	el.Lock()
+	el.chain = chain
+	el.cache = make(map[string]cachedSignature)
+	el.Unlock()
+}
+
+// NSEC3 hash of a name, RFC 5155 section 5
+func (el *dnssecSigner) nsec3Hash(name string) []byte {
+	hash := sha1.New()
+	_, _ = hash.Write(wireAppendName(nil, name))
+	_, _ = hash.Write(el.nsec3Param.Salt)
+	sum := hash.Sum(nil)
+
+	for k := 0; k < int(el.nsec3Param.Iterations); k++ {
+		hash.Reset()
+		_, _ = hash.Write(sum)
+		_, _ = hash.Write(el.nsec3Param.Salt)
+		sum = hash.Sum(nil)
+	}
+
+	return sum
+}
+
+func (el *dnssecSigner) dnskeys() []dns.Record {
+	var records []dns.Record
+
+	for _, key := range el.ksk {
+		records = append(records, key.dnskey)
+	}
+
+	if el.zsk[0] != el.ksk[0] {
+		for _, key := range el.zsk {
+			records = append(records, key.dnskey)
+		}
+	}
+
+	return records
+}
+
+// signatures of the records of the same name and type. DNSKEY records are signed by the KSK, everything else by the ZSK
+func (el *dnssecSigner) Sign(name string, ttl time.Duration, records []dns.Record) ([]*RRSIG, error) {
+	var keys = el.zsk
+	var data [][]byte
+	var recordType = records[0].Type()
+	var owner = strings.ToLower(name)
+	var now = time.Now()
+
+	if recordType == kTypeDNSKEY {
+		keys = el.ksk
+	}
+
+	// RRs in the canonical form, RFC 4034 section 6
+	for _, record := range records {
+		rdata, err := canonicalRData(record)
+		if err != nil {
+			return nil, err
+		}
+
+		rr := wireAppendName(nil, owner)
+		rr = wireAppendUint16(rr, uint16(recordType))
+		rr = wireAppendUint16(rr, uint16(dns.ClassIN))
+		rr = wireAppendUint32(rr, uint32(ttl/time.Second))
+		rr = wireAppendUint16(rr, uint16(len(rdata)))
+		data = append(data, append(rr, rdata...))
+	}
+
+	sort.Slice(data, func(i, j int) bool { return bytes.Compare(data[i], data[j]) < 0 })
+
+	cacheKey := string(bytes.Join(data, nil))
+
+	el.RLock()
+	cached, ok := el.cache[cacheKey]
+	el.RUnlock()
+
+	if ok && time.Unix(int64(cached.rrsig[0].Expiration), 0).Sub(now) > el.validity/4 {
+		return cached.rrsig, nil
+	}
+
+	cached = cachedSignature{}
+	for _, key := range keys {
+		rrsig := &RRSIG{
+			TypeCovered: recordType,
+			Algorithm:   key.dnskey.Algorithm,
+			Labels:      nameLabels(owner),
+			OriginalTTL: uint32(ttl / time.Second),
+			Expiration:  uint32(now.Add(el.validity).Unix()),
+			Inception:   uint32(now.Add(-kDnssecInceptionOffset).Unix()),
+			KeyTag:      key.keyTag,
+			SignerName:  el.zone.Origin(),
+		}
+
+		toSign := rrsig.packHeader(nil)
+		for k := range data {
+			// duplicated records are signed once
+			if k == 0 || !bytes.Equal(data[k], data[k-1]) {
+				toSign = append(toSign, data[k]...)
+			}
+		}
+
+		signature, err := key.Sign(toSign)
+		if err != nil {
+			return nil, err
+		}
+		rrsig.Signature = signature
+
+		cached.rrsig = append(cached.rrsig, rrsig)
+	}
+
+	el.Lock()
+	if len(el.cache) >= kDnssecMaxCachedSignatures {
+		el.cache = make(map[string]cachedSignature)
+	}
+	el.cache[cacheKey] = cached
+	el.Unlock()
+
+	return cached.rrsig, nil
+}
+
+// serve the query with handler and add the signatures and the denial of existence to the answer
+func (el *dnssecSigner) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query, handler dns.Handler) {
+	var signing = dnssecOk(r)
+	var question = r.Questions[0]
+	var ttl = el.zone.TTL()
+
+	if strings.ToLower(question.Name) == el.zone.Origin() {
+		var records []dns.Record
+
+		switch question.Type {
+		case kTypeDNSKEY:
+			records = el.dnskeys()
+		case kTypeNSEC3PARAM:
+			if el.nsec3Param != nil {
+				records = []dns.Record{el.nsec3Param}
+			}
+		}
+
+		if len(records) != 0 {
+			w.Authoritative(true)
+			for _, record := range records {
+				w.Answer(question.Name, ttl, record)
+			}
+
+			if signing {
+				el.writeSignatures(w.Answer, question.Name, ttl, records)
+			}
+			return
+		}
+	}
+
+	if !signing {
+		handler.ServeDNS(ctx, w, r)
+		return
+	}
+
+	recorder := &recordingWriter{MessageWriter: w}
+	handler.ServeDNS(ctx, recorder, r)
+
+	el.signSection(w.Answer, recorder.response.Answers)
+	el.signSection(w.Authority, recorder.response.Authorities)
+	el.signSection(w.Additional, recorder.response.Additionals)
+
+	if len(recorder.response.Answers) == 0 && (recorder.response.RCode == dns.NoError || recorder.response.RCode == dns.NXDomain) {
+		el.deny(w, question)
+	}
+}
+
+func (el *dnssecSigner) writeSignatures(write func(string, time.Duration, dns.Record), name string, ttl time.Duration, records []dns.Record) {
+	signatures, err := el.Sign(name, ttl, records)
+	if err != nil {
+		el.onError(err)
+		return
+	}
+
+	for _, rrsig := range signatures {
+		write(name, ttl, rrsig)
+	}
+}
+
+// sign each group of records of the same name and type written in a section of the response
+func (el *dnssecSigner) signSection(write func(string, time.Duration, dns.Record), resources []dns.Resource) {
+	var groups = make(map[string][]dns.Record)
+	var order []dns.Resource
+
+	for _, resource := range resources {
+		key := strings.ToLower(resource.Name) + "/" + typeName(resource.Record.Type())
+		if _, ok := groups[key]; !ok {
+			order = append(order, resource)
+		}
+		groups[key] = append(groups[key], resource.Record)
+	}
+
+	for _, resource := range order {
+		key := strings.ToLower(resource.Name) + "/" + typeName(resource.Record.Type())
+		el.writeSignatures(write, resource.Name, resource.TTL, groups[key])
+	}
+}
+
+// NSEC/NSEC3 records that prove that the name or the type doesn't exist, RFC 4035 section 3.1.3 and RFC 5155 section
+// 7.2. The rcode follows the chain: a name with other types, or with names below it, is NODATA and not NXDOMAIN.
+func (el *dnssecSigner) deny(w dns.MessageWriter, question dns.Question) {
+	var proof []dnssecNode
+	var name = strings.ToLower(question.Name)
+	var origin = el.zone.Origin()
+
+	el.RLock()
+	chain := el.chain
+	el.RUnlock()
+
+	if name != origin && !strings.HasSuffix(name, "."+origin) {
+		return
+	}
+
+	if el.denial == kDnssecDenialNsec {
+		if node, ok := el.nsecMatch(chain, name); ok {
+			proof = append(proof, node)
+			w.Status(dns.NoError)
+		} else {
+			cover := el.nsecCover(chain, name)
+			proof = append(proof, cover)
+
+			// names below the query name: the name is an empty non-terminal
+			if !strings.HasSuffix(cover.record.(*NSEC).NextDomain, "."+name) {
+				encloser := el.nsecClosestEncloser(chain, name)
+				wildcard := el.nsecCover(chain, "*."+encloser)
+				if wildcard.name != cover.name {
+					proof = append(proof, wildcard)
+				}
+
+				w.Status(dns.NXDomain)
+			} else {
+				w.Status(dns.NoError)
+			}
+		}
+	} else {
+		if node, ok := el.nsec3Match(chain, name); ok {
+			proof = append(proof, node)
+			w.Status(dns.NoError)
+		} else {
+			encloser, nextCloser := el.nsec3ClosestEncloser(chain, name)
+			proof = append(proof, encloser)
+
+			cover := el.nsec3Cover(chain, nextCloser)
+			if cover.owner != encloser.owner {
+				proof = append(proof, cover)
+			}
+
+			wildcard := el.nsec3Cover(chain, "*."+encloser.name)
+			if wildcard.owner != encloser.owner && wildcard.owner != cover.owner {
+				proof = append(proof, wildcard)
+			}
+
+			w.Status(dns.NXDomain)
+		}
+	}
+
+	// the denial records have the TTL of the negative answer, RFC 4035 section 2.3
+	ttl := el.zone.NegativeTTL()
+	for _, node := range proof {
+		w.Authority(node.owner, ttl, node.record)
+		el.writeSignatures(w.Authority, node.owner, ttl, []dns.Record{node.record})
+	}
+}
+
+func (el *dnssecSigner) nsecMatch(chain []dnssecNode, name string) (dnssecNode, bool) {
+	for _, node := range chain {
+		if node.name == name {
+			return node, true
+		}
+	}
+
+	return dnssecNode{}, false
+}
+
+// NSEC record of the last name before the name, in the canonical order
+func (el *dnssecSigner) nsecCover(chain []dnssecNode, name string) dnssecNode {
+	var cover = chain[len(chain)-1]
+
+	for _, node := range chain {
+		if !canonicalLess(node.name, name) {
+			break
+		}
+		cover = node
+	}
+
+	return cover
+}
+
+func (el *dnssecSigner) nsecClosestEncloser(chain []dnssecNode, name string) string {
+	for parent := parentName(name); parent != el.zone.Origin(); parent = parentName(parent) {
+		if _, ok := el.nsecMatch(chain, parent); ok {
+			return parent
+		}
+	}
+
+	return el.zone.Origin()
+}
+
+func (el *dnssecSigner) nsec3Match(chain []dnssecNode, name string) (dnssecNode, bool) {
+	hash := el.nsec3Hash(name)
+
+	for _, node := range chain {
+		if bytes.Equal(node.hash, hash) {
+			return node, true
+		}
+	}
+
+	return dnssecNode{}, false
+}
+
+// NSEC3 record of the last hash before the hash of the name
+func (el *dnssecSigner) nsec3Cover(chain []dnssecNode, name string) dnssecNode {
+	var hash = el.nsec3Hash(name)
+	var cover = chain[len(chain)-1]
+
+	for _, node := range chain {
+		if bytes.Compare(node.hash, hash) >= 0 {
+			break
+		}
+		cover = node
+	}
+
+	return cover
+}
+
+// NSEC3 record of the closest encloser of the name and the next closer name, RFC 5155 section 7.2.1
+func (el *dnssecSigner) nsec3ClosestEncloser(chain []dnssecNode, name string) (dnssecNode, string) {
+	nextCloser := name
+
+	for parent := parentName(name); ; parent = parentName(parent) {
+		if node, ok := el.nsec3Match(chain, parent); ok {
+			return node, nextCloser
+		}
+		nextCloser = parent
+	}
+}
+
+// "a.b.tld." to "b.tld."
+func parentName(name string) string {
+	index := strings.Index(name, ".")
+	if index == -1 || index == len(name)-1 {
+		return "."
+	}
+
+	return name[index+1:]
+}
+
+// labels of a name, without the root and the wildcard label, as used by RRSIG
+func nameLabels(name string) uint8 {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return 0
+	}
+
+	labels := strings.Split(name, ".")
+	if labels[0] == "*" {
+		return uint8(len(labels) - 1)
+	}
+
+	return uint8(len(labels))
+}
+
+// canonical order of names, RFC 4034 section 6.1
+func canonicalLess(a, b string) bool {
+	aLabels := strings.Split(strings.TrimSuffix(strings.ToLower(a), "."), ".")
+	bLabels := strings.Split(strings.TrimSuffix(strings.ToLower(b), "."), ".")
+
+	for i, j := len(aLabels)-1, len(bLabels)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if aLabels[i] != bLabels[j] {
+			return aLabels[i] < bLabels[j]
+		}
+	}
+
+	return len(aLabels) < len(bLabels)
+}
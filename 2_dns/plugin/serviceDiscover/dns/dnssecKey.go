@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	kDnssecAlgorithmRSASHA256       = 8
+	kDnssecAlgorithmECDSAP256SHA256 = 13
+
+	kDnskeyFlagZone = 0x0100
+	kDnskeyFlagSEP  = 0x0001
+)
+
+// key pair loaded from the files made by dnssec-keygen: Kzone.+alg+tag.key and Kzone.+alg+tag.private
+type dnssecKey struct {
+	dnskey  *DNSKEY
+	keyTag  uint16
+	private crypto.Signer
+}
+
+// true for a key signing key, used only to sign the DNSKEY records
+func (el *dnssecKey) isKSK() bool {
+	return el.dnskey.Flags&kDnskeyFlagSEP != 0
+}
+
+// load a key by the path of its files, without the extension. ex.: "/etc/dns/Ktld.+013+12345"
+func loadDnssecKey(path string) (*dnssecKey, error) {
+	var err error
+	var key = &dnssecKey{}
+
+	key.dnskey, err = readDnskeyFile(path + ".key")
+	if err != nil {
+		return nil, err
+	}
+
+	if key.dnskey.Flags&kDnskeyFlagZone == 0 || key.dnskey.Protocol != 3 {
+		return nil, errors.New("dnssec key " + path + " is not a zone key")
+	}
+
+	fields, err := readPrivateKeyFile(path + ".private")
+	if err != nil {
+		return nil, err
+	}
+
+	// ex.: "Algorithm: 13 (ECDSAP256SHA256)"
+	algorithm := strings.Fields(fields["Algorithm"])
+	if len(algorithm) == 0 || algorithm[0] != strconv.Itoa(int(key.dnskey.Algorithm)) {
+		return nil, errors.New("dnssec key " + path + " algorithm of the private key doesn't match the public key")
+	}
+
+	switch key.dnskey.Algorithm {
+	case kDnssecAlgorithmECDSAP256SHA256:
+		key.private, err = ecdsaPrivateKey(fields, key.dnskey.PublicKey)
+	case kDnssecAlgorithmRSASHA256:
+		key.private, err = rsaPrivateKey(fields)
+	default:
+		err = errors.New("dnssec key algorithm must be 8 (RSASHA256) or 13 (ECDSAP256SHA256)")
+	}
+	if err != nil {
+		return nil, errors.New("dnssec key " + path + ": " + err.Error())
+	}
+
+	key.keyTag = key.dnskey.KeyTag()
+
+	return key, nil
+}
+
+// DNSKEY record of a .key file. ex.: "tld. IN DNSKEY 257 3 13 base64..."
+func readDnskeyFile(path string) (*DNSKEY, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+
+		for k := range fields {
+			if fields[k] != "DNSKEY" || len(fields) < k+5 {
+				continue
+			}
+
+			flags, err := strconv.ParseUint(fields[k+1], 10, 16)
+			if err != nil {
+				return nil, err
+			}
+
+			protocol, err := strconv.ParseUint(fields[k+2], 10, 8)
+			if err != nil {
+				return nil, err
+			}
+
+			algorithm, err := strconv.ParseUint(fields[k+3], 10, 8)
+			if err != nil {
+				return nil, err
+			}
+
+			publicKey, err := base64.StdEncoding.DecodeString(strings.Join(fields[k+4:], ""))
+			if err != nil {
+				return nil, err
+			}
+
+			return &DNSKEY{Flags: uint16(flags), Protocol: uint8(protocol), Algorithm: uint8(algorithm), PublicKey: publicKey}, nil
+		}
+	}
+
+	return nil, errors.New("DNSKEY record not found in " + path)
+}
+
+// "Field: value" lines of a .private file
+func readPrivateKeyFile(path string) (map[string]string, error) {
+	var fields = make(map[string]string)
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			fields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return fields, nil
+}
+
+func privateKeyNumber(fields map[string]string, name string) (*big.Int, error) {
+	value, err := base64.StdEncoding.DecodeString(fields[name])
+	if err != nil || len(value) == 0 {
+		return nil, errors.New("private key field " + name + " not found")
+	}
+
+	return new(big.Int).SetBytes(value), nil
+}
+
+func ecdsaPrivateKey(fields map[string]string, publicKey []byte) (*ecdsa.PrivateKey, error) {
+	var key = &ecdsa.PrivateKey{}
+	var err error
+
+	key.D, err = privateKeyNumber(fields, "PrivateKey")
+	if err != nil {
+		return nil, err
+	}
+
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(key.D.Bytes())
+
+	if len(publicKey) != 64 || key.X.Cmp(new(big.Int).SetBytes(publicKey[:32])) != 0 || key.Y.Cmp(new(big.Int).SetBytes(publicKey[32:])) != 0 {
+		return nil, errors.New("private key doesn't match the public key")
+	}
+
+	return key, nil
+}
+
+func rsaPrivateKey(fields map[string]string) (*rsa.PrivateKey, error) {
+	var key = &rsa.PrivateKey{}
+	var numbers = make([]*big.Int, 5)
+
+	for k, name := range []string{"Modulus", "PublicExponent", "PrivateExponent", "Prime1", "Prime2"} {
+		number, err := privateKeyNumber(fields, name)
+		if err != nil {
+			return nil, err
+		}
+		numbers[k] = number
+	}
+
+	key.N = numbers[0]
+	key.E = int(numbers[1].Int64())
+	key.D = numbers[2]
+	key.Primes = []*big.Int{numbers[3], numbers[4]}
+
+	err := key.Validate()
+	if err != nil {
+		return nil, err
+	}
+	key.Precompute()
+
+	return key, nil
+}
+
+// signature of the data in the DNSSEC format of the key algorithm
+func (el *dnssecKey) Sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+
+	switch private := el.private.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, private, hash[:])
+		if err != nil {
+			return nil, err
+		}
+
+		// r and s with 32 bytes each, RFC 6605
+		signature := make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+		return signature, nil
+
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, hash[:])
+	}
+
+	return nil, errors.New("dnssec key algorithm not supported")
+}
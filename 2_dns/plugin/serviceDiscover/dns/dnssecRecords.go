@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"github.com/helmutkemper/dns"
+	"sort"
+)
+
+// DNSSEC records - RFC 4034 and RFC 5155. The DNS library doesn't know them, so they are packed here. Names inside
+// these records are never compressed.
+const (
+	kTypeRRSIG      dns.Type = 46
+	kTypeNSEC       dns.Type = 47
+	kTypeDNSKEY     dns.Type = 48
+	kTypeNSEC3      dns.Type = 50
+	kTypeNSEC3PARAM dns.Type = 51
+)
+
+var errDnssecUnpack = errors.New("dnssec records are only sent by this server")
+
+// compressor used to pack the rdata of a record in the canonical form: names without compression and in lower case
+type canonicalCompressor struct{}
+
+func (canonicalCompressor) Length(names ...string) (int, error) {
+	var length = 0
+	for _, name := range names {
+		length += len(wireAppendName(nil, name))
+	}
+
+	return length, nil
+}
+
+func (canonicalCompressor) Pack(b []byte, fqdn string) ([]byte, error) {
+	return wireAppendName(b, fqdn), nil
+}
+
+// rdata of a record in the canonical form, used by signatures
+func canonicalRData(record dns.Record) ([]byte, error) {
+	return record.Pack(nil, canonicalCompressor{})
+}
+
+type DNSKEY struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+}
+
+func (rr *DNSKEY) Type() dns.Type { return kTypeDNSKEY }
+
+func (rr *DNSKEY) Length(_ dns.Compressor) (int, error) { return 4 + len(rr.PublicKey), nil }
+
+func (rr *DNSKEY) Pack(b []byte, _ dns.Compressor) ([]byte, error) {
+	b = wireAppendUint16(b, rr.Flags)
+	b = append(b, rr.Protocol, rr.Algorithm)
+	return append(b, rr.PublicKey...), nil
+}
+
+func (rr *DNSKEY) Unpack(b []byte, _ dns.Decompressor) ([]byte, error) { return b, errDnssecUnpack }
+
+// key tag of the key, RFC 4034 appendix B
+func (rr *DNSKEY) KeyTag() uint16 {
+	var sum uint32
+
+	rdata, _ := rr.Pack(nil, nil)
+	for k, value := range rdata {
+		if k&1 == 0 {
+			sum += uint32(value) << 8
+		} else {
+			sum += uint32(value)
+		}
+	}
+	sum += sum >> 16 & 0xffff
+
+	return uint16(sum)
+}
+
+type RRSIG struct {
+	TypeCovered dns.Type
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+func (rr *RRSIG) Type() dns.Type { return kTypeRRSIG }
+
+func (rr *RRSIG) Length(_ dns.Compressor) (int, error) {
+	b, _ := rr.Pack(nil, nil)
+	return len(b), nil
+}
+
+// rdata without the signature, the first part of the data signed
+func (rr *RRSIG) packHeader(b []byte) []byte {
+	b = wireAppendUint16(b, uint16(rr.TypeCovered))
+	b = append(b, rr.Algorithm, rr.Labels)
+	b = wireAppendUint32(b, rr.OriginalTTL)
+	b = wireAppendUint32(b, rr.Expiration)
+	b = wireAppendUint32(b, rr.Inception)
+	b = wireAppendUint16(b, rr.KeyTag)
+	return wireAppendName(b, rr.SignerName)
+}
+
+func (rr *RRSIG) Pack(b []byte, _ dns.Compressor) ([]byte, error) {
+	return append(rr.packHeader(b), rr.Signature...), nil
+}
+
+func (rr *RRSIG) Unpack(b []byte, _ dns.Decompressor) ([]byte, error) { return b, errDnssecUnpack }
+
+type NSEC struct {
+	NextDomain string
+	TypeBitMap []dns.Type
+}
+
+func (rr *NSEC) Type() dns.Type { return kTypeNSEC }
+
+func (rr *NSEC) Length(_ dns.Compressor) (int, error) {
+	b, _ := rr.Pack(nil, nil)
+	return len(b), nil
+}
+
+func (rr *NSEC) Pack(b []byte, _ dns.Compressor) ([]byte, error) {
+	b = wireAppendName(b, rr.NextDomain)
+	return appendTypeBitMap(b, rr.TypeBitMap), nil
+}
+
+func (rr *NSEC) Unpack(b []byte, _ dns.Decompressor) ([]byte, error) { return b, errDnssecUnpack }
+
+type NSEC3 struct {
+	Hash       uint8
+	Flags      uint8
+	Iterations uint16
+	Salt       []byte
+	NextHashed []byte
+	TypeBitMap []dns.Type
+}
+
+func (rr *NSEC3) Type() dns.Type { return kTypeNSEC3 }
+
+func (rr *NSEC3) Length(_ dns.Compressor) (int, error) {
+	b, _ := rr.Pack(nil, nil)
+	return len(b), nil
+}
+
+func (rr *NSEC3) Pack(b []byte, _ dns.Compressor) ([]byte, error) {
+	b = append(b, rr.Hash, rr.Flags)
+	b = wireAppendUint16(b, rr.Iterations)
+	b = append(b, byte(len(rr.Salt)))
+	b = append(b, rr.Salt...)
+	b = append(b, byte(len(rr.NextHashed)))
+	b = append(b, rr.NextHashed...)
+	return appendTypeBitMap(b, rr.TypeBitMap), nil
+}
+
+func (rr *NSEC3) Unpack(b []byte, _ dns.Decompressor) ([]byte, error) { return b, errDnssecUnpack }
+
+type NSEC3PARAM struct {
+	Hash       uint8
+	Flags      uint8
+	Iterations uint16
+	Salt       []byte
+}
+
+func (rr *NSEC3PARAM) Type() dns.Type { return kTypeNSEC3PARAM }
+
+func (rr *NSEC3PARAM) Length(_ dns.Compressor) (int, error) { return 5 + len(rr.Salt), nil }
+
+func (rr *NSEC3PARAM) Pack(b []byte, _ dns.Compressor) ([]byte, error) {
+	b = append(b, rr.Hash, rr.Flags)
+	b = wireAppendUint16(b, rr.Iterations)
+	b = append(b, byte(len(rr.Salt)))
+	return append(b, rr.Salt...), nil
+}
+
+func (rr *NSEC3PARAM) Unpack(b []byte, _ dns.Decompressor) ([]byte, error) { return b, errDnssecUnpack }
+
+// type bit map of NSEC and NSEC3 records, RFC 4034 section 4.1.2
+func appendTypeBitMap(b []byte, types []dns.Type) []byte {
+	var sorted = append([]dns.Type{}, types...)
+	var window = -1
+	var bitmap []byte
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	flush := func() {
+		if window != -1 {
+			b = append(b, byte(window), byte(len(bitmap)))
+			b = append(b, bitmap...)
+		}
+	}
+
+	for _, recordType := range sorted {
+		if int(recordType>>8) != window {
+			flush()
+			window = int(recordType >> 8)
+			bitmap = nil
+		}
+
+		position := int(recordType&0xff) / 8
+		for len(bitmap) <= position {
+			bitmap = append(bitmap, 0)
+		}
+		bitmap[position] |= 0x80 >> (recordType & 0x07)
+	}
+	flush()
+
+	return b
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/helmutkemper/dns"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signer of testZone with a new ECDSAP256SHA256 key, saved in the files of dnssec-keygen
+func testDnssecSigner(t *testing.T, denial string) (*dnssecSigner, *ecdsa.PrivateKey) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "dnssec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	publicKey := make([]byte, 64)
+	xBytes, yBytes := private.X.Bytes(), private.Y.Bytes()
+	copy(publicKey[32-len(xBytes):32], xBytes)
+	copy(publicKey[64-len(yBytes):], yBytes)
+
+	path := filepath.Join(dir, "Ktld.+013+00001")
+	testDnssecWrite(t, path+".key", "tld. IN DNSKEY 257 3 13 "+base64.StdEncoding.EncodeToString(publicKey)+"\n")
+	testDnssecWrite(t, path+".private", "Private-key-format: v1.3\nAlgorithm: 13 (ECDSAP256SHA256)\nPrivateKey: "+base64.StdEncoding.EncodeToString(private.D.Bytes())+"\n")
+
+	signer, err := newDnssecSigner(dnssecConfig{Keys: []string{path}, Denial: denial}, testZone(), func(err error) { t.Error(err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer, private
+}
+
+func testDnssecWrite(t *testing.T, filePath, content string) {
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checks the signature of the records with the public key, RFC 4034 section 3.1.8.1
+func testDnssecVerify(t *testing.T, public *ecdsa.PublicKey, rrsig *RRSIG, name string, ttl time.Duration, records []dns.Record) {
+	var data [][]byte
+
+	for _, record := range records {
+		rdata, err := canonicalRData(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := wireAppendName(nil, strings.ToLower(name))
+		rr = wireAppendUint16(rr, uint16(record.Type()))
+		rr = wireAppendUint16(rr, uint16(dns.ClassIN))
+		rr = wireAppendUint32(rr, uint32(ttl/time.Second))
+		rr = wireAppendUint16(rr, uint16(len(rdata)))
+		data = append(data, append(rr, rdata...))
+	}
+
+	sort.Slice(data, func(i, j int) bool { return string(data[i]) < string(data[j]) })
+
+	signed := rrsig.packHeader(nil)
+	for _, rr := range data {
+		signed = append(signed, rr...)
+	}
+
+	hash := sha256.Sum256(signed)
+	r := new(big.Int).SetBytes(rrsig.Signature[:32])
+	s := new(big.Int).SetBytes(rrsig.Signature[32:])
+
+	if !ecdsa.Verify(public, hash[:], r, s) {
+		t.Errorf("signature of %v %v doesn't match", name, typeName(records[0].Type()))
+	}
+}
+
+// query with the DO flag, served by the signer and the zone
+func testDnssecServe(signer *dnssecSigner, name string, recordType dns.Type) dns.Message {
+	w := &testWriter{}
+	signer.ServeDNS(context.Background(), w, &dns.Query{Message: &dns.Message{
+		Questions:   []dns.Question{{Name: name, Type: recordType, Class: dns.ClassIN}},
+		Additionals: []dns.Resource{{Name: ".", TTL: kEdnsFlagDnssecOk * time.Second, Record: &dns.OPT{}}},
+	}}, signer.zone)
+
+	return w.response
+}
+
+func TestDnssecSignVerify(t *testing.T) {
+	signer, private := testDnssecSigner(t, kDnssecDenialNsec)
+	records := signer.dnskeys()
+
+	signatures, err := signer.Sign("TLD.", time.Hour, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(signatures) != 1 {
+		t.Fatalf("signatures error, want=1, get=%v", len(signatures))
+	}
+
+	rrsig := signatures[0]
+	if rrsig.TypeCovered != kTypeDNSKEY || rrsig.Labels != 1 || rrsig.SignerName != "tld." || rrsig.KeyTag != records[0].(*DNSKEY).KeyTag() {
+		t.Errorf("rrsig error, get=%+v", rrsig)
+	}
+
+	testDnssecVerify(t, &private.PublicKey, rrsig, "tld.", time.Hour, records)
+
+	// the signature is kept in the cache until the zone changes
+	cached, _ := signer.Sign("tld.", time.Hour, records)
+	if cached[0] != rrsig {
+		t.Errorf("signature must be cached")
+	}
+
+	signer.Changed()
+	if changed, _ := signer.Sign("tld.", time.Hour, records); changed[0] == rrsig {
+		t.Errorf("signature must be made again after a change")
+	}
+}
+
+func TestDnssecAnswerSigned(t *testing.T) {
+	signer, private := testDnssecSigner(t, kDnssecDenialNsec)
+	response := testDnssecServe(signer, "node.tld.", dns.TypeSRV)
+
+	if len(response.Answers) != 2 {
+		t.Fatalf("answer section error, want=SRV and RRSIG, get=%+v", response.Answers)
+	}
+
+	rrsig, ok := response.Answers[1].Record.(*RRSIG)
+	if !ok || rrsig.TypeCovered != dns.TypeSRV {
+		t.Fatalf("answer section error, want=RRSIG of SRV, get=%+v", response.Answers[1].Record)
+	}
+
+	testDnssecVerify(t, &private.PublicKey, rrsig, "node.tld.", response.Answers[0].TTL, []dns.Record{response.Answers[0].Record})
+}
+
+// NSEC records of the negative answer and their signatures
+func testDnssecProof(t *testing.T, response dns.Message, rrType dns.Type) []dns.Resource {
+	var proof []dns.Resource
+	var signed = make(map[string]bool)
+
+	for _, resource := range response.Authorities {
+		switch record := resource.Record.(type) {
+		case *RRSIG:
+			signed[resource.Name+"/"+typeName(record.TypeCovered)] = true
+		default:
+			if record.Type() == rrType {
+				proof = append(proof, resource)
+			}
+		}
+	}
+
+	for _, resource := range proof {
+		if !signed[resource.Name+"/"+typeName(rrType)] {
+			t.Errorf("%v of %v must be signed", typeName(rrType), resource.Name)
+		}
+	}
+
+	return proof
+}
+
+func testHasType(types []dns.Type, rrType dns.Type) bool {
+	for _, value := range types {
+		if value == rrType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestDnssecNsecNxDomain(t *testing.T) {
+	signer, _ := testDnssecSigner(t, kDnssecDenialNsec)
+	response := testDnssecServe(signer, "missing.tld.", dns.TypeSRV)
+
+	if response.RCode != dns.NXDomain {
+		t.Errorf("rcode error, want=%v, get=%v", dns.NXDomain, response.RCode)
+	}
+
+	var covered bool
+	for _, resource := range testDnssecProof(t, response, kTypeNSEC) {
+		next := resource.Record.(*NSEC).NextDomain
+		if canonicalLess(resource.Name, "missing.tld.") && (canonicalLess("missing.tld.", next) || next == "tld.") {
+			covered = true
+		}
+	}
+
+	if !covered {
+		t.Errorf("no NSEC record covers missing.tld., get=%+v", response.Authorities)
+	}
+}
+
+func TestDnssecNsecNoData(t *testing.T) {
+	signer, _ := testDnssecSigner(t, kDnssecDenialNsec)
+	response := testDnssecServe(signer, "node1.tld.", dns.TypeTXT)
+
+	if response.RCode != dns.NoError || len(response.Answers) != 0 {
+		t.Errorf("answer error, want=NODATA, get=%+v", response)
+	}
+
+	proof := testDnssecProof(t, response, kTypeNSEC)
+	if len(proof) != 1 || proof[0].Name != "node1.tld." {
+		t.Fatalf("proof error, want=NSEC of node1.tld., get=%+v", proof)
+	}
+
+	types := proof[0].Record.(*NSEC).TypeBitMap
+	if !testHasType(types, dns.TypeA) || testHasType(types, dns.TypeTXT) {
+		t.Errorf("type bitmap error, get=%v", types)
+	}
+}
+
+func TestDnssecNsec3NxDomain(t *testing.T) {
+	signer, _ := testDnssecSigner(t, kDnssecDenialNsec3)
+	response := testDnssecServe(signer, "missing.tld.", dns.TypeSRV)
+
+	if response.RCode != dns.NXDomain {
+		t.Errorf("rcode error, want=%v, get=%v", dns.NXDomain, response.RCode)
+	}
+
+	// closest encloser, next closer name and wildcard, some of them may be the same record
+	proof := testDnssecProof(t, response, kTypeNSEC3)
+	if len(proof) == 0 || len(proof) > 3 {
+		t.Fatalf("proof error, want=1 to 3 NSEC3 records, get=%+v", proof)
+	}
+
+	origin := strings.ToLower(nsec3Encoding.EncodeToString(signer.nsec3Hash("tld."))) + ".tld."
+	if proof[0].Name != origin {
+		t.Errorf("closest encloser error, want=%v, get=%v", origin, proof[0].Name)
+	}
+}
+
+func TestDnssecNsec3NoData(t *testing.T) {
+	signer, _ := testDnssecSigner(t, kDnssecDenialNsec3)
+	response := testDnssecServe(signer, "node1.tld.", dns.TypeTXT)
+
+	if response.RCode != dns.NoError || len(response.Answers) != 0 {
+		t.Errorf("answer error, want=NODATA, get=%+v", response)
+	}
+
+	proof := testDnssecProof(t, response, kTypeNSEC3)
+	owner := strings.ToLower(nsec3Encoding.EncodeToString(signer.nsec3Hash("node1.tld."))) + ".tld."
+	if len(proof) != 1 || proof[0].Name != owner {
+		t.Fatalf("proof error, want=NSEC3 of node1.tld., get=%+v", proof)
+	}
+
+	types := proof[0].Record.(*NSEC3).TypeBitMap
+	if !testHasType(types, dns.TypeA) || testHasType(types, dns.TypeTXT) {
+		t.Errorf("type bitmap error, get=%v", types)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// dnstap - http://dnstap.info
+// The dnstap protobuf message is small, so it is encoded here by hand instead of generated code.
+const (
+	kDnstapContentType = "protobuf:dnstap.Dnstap"
+
+	kFrameStreamControlAccept      = 0x01
+	kFrameStreamControlStart       = 0x02
+	kFrameStreamControlStop        = 0x03
+	kFrameStreamControlReady       = 0x04
+	kFrameStreamControlFinish      = 0x05
+	kFrameStreamControlMaxLength   = 512
+	kFrameStreamFieldContentType   = 0x01
+	kDnstapTypeMessage             = 1
+	kDnstapMessageTypeClientQuery  = 5
+	kDnstapMessageTypeClientAnswer = 6
+	kDnstapSocketFamilyInet        = 1
+	kDnstapSocketFamilyInet6       = 2
+	kDnstapSocketProtocolUdp       = 1
+	kDnstapSocketProtocolTcp       = 2
+
+	kDnstapReconnectInterval = 5 * time.Second
+	kDnstapHandshakeTimeOut  = 5 * time.Second
+)
+
+// dnstapWriter writes a Frame Streams stream with one CLIENT_QUERY and one CLIENT_RESPONSE message for each query. The
+// destination is a unix socket ("unix:/path"), a tcp address ("tcp:host:port") or a file.
+// Sockets use the bidirectional stream, READY, ACCEPT and START, and STOP and FINISH at the end, as the collectors
+// expect, ex.: fstrm_capture. Files use the unidirectional stream, START and STOP.
+type dnstapWriter struct {
+	destination string
+	identity    string
+	onError     func(error)
+	writer      io.WriteCloser
+	buffer      *bufio.Writer
+	lastFail    time.Time
+	// socket of the collector, nil for files
+	conn net.Conn
+	// the file is truncated only on the first open, so the reconnections keep the frames written before
+	opened bool
+}
+
+func newDnstapWriter(destination, identity string, onError func(error)) *dnstapWriter {
+	return &dnstapWriter{
+		destination: destination,
+		identity:    identity,
+		onError:     onError,
+	}
+}
+
+func (el *dnstapWriter) connect() error {
+	var err error
+
+	switch {
+	case strings.HasPrefix(el.destination, "unix:"):
+		el.conn, err = net.Dial("unix", strings.TrimPrefix(el.destination, "unix:"))
+		el.writer = el.conn
+	case strings.HasPrefix(el.destination, "tcp:"):
+		el.conn, err = net.Dial("tcp", strings.TrimPrefix(el.destination, "tcp:"))
+		el.writer = el.conn
+	default:
+		flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if !el.opened {
+			flag |= os.O_TRUNC
+		}
+		el.writer, err = os.OpenFile(el.destination, flag, 0644)
+		el.opened = el.opened || err == nil
+	}
+	if err != nil {
+		el.conn = nil
+		el.writer = nil
+		return err
+	}
+
+	el.buffer = bufio.NewWriter(el.writer)
+
+	if el.conn != nil {
+		err = el.handshake()
+		if err != nil {
+			return err
+		}
+	}
+
+	return el.control(kFrameStreamControlStart, []byte(kDnstapContentType))
+}
+
+// READY with the content type, answered by the collector with ACCEPT
+func (el *dnstapWriter) handshake() error {
+	err := el.conn.SetDeadline(time.Now().Add(kDnstapHandshakeTimeOut))
+	if err != nil {
+		return err
+	}
+
+	err = el.control(kFrameStreamControlReady, []byte(kDnstapContentType))
+	if err != nil {
+		return err
+	}
+
+	controlType, contentTypes, err := el.readControl()
+	if err != nil {
+		return err
+	}
+
+	if controlType != kFrameStreamControlAccept {
+		return fmt.Errorf("dnstap collector answered READY with the control frame %v, expected ACCEPT", controlType)
+	}
+
+	accepted := len(contentTypes) == 0
+	for _, contentType := range contentTypes {
+		accepted = accepted || bytes.Equal(contentType, []byte(kDnstapContentType))
+	}
+
+	if !accepted {
+		return errors.New("dnstap collector doesn't accept the content type " + kDnstapContentType)
+	}
+
+	return el.conn.SetDeadline(time.Time{})
+}
+
+// control frame sent by the collector: escape (0), length, type and the content type fields
+func (el *dnstapWriter) readControl() (uint32, [][]byte, error) {
+	var contentTypes [][]byte
+	var header = make([]byte, 8)
+
+	_, err := io.ReadFull(el.conn, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[4:])
+	if binary.BigEndian.Uint32(header) != 0 || length < 4 || length > kFrameStreamControlMaxLength {
+		return 0, nil, errors.New("dnstap collector sent an invalid control frame")
+	}
+
+	frame := make([]byte, length)
+	_, err = io.ReadFull(el.conn, frame)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for fields := frame[4:]; len(fields) != 0; {
+		if len(fields) < 8 || uint32(len(fields)-8) < binary.BigEndian.Uint32(fields[4:]) {
+			return 0, nil, errors.New("dnstap collector sent an invalid control frame")
+		}
+
+		fieldLength := binary.BigEndian.Uint32(fields[4:])
+		if binary.BigEndian.Uint32(fields) == kFrameStreamFieldContentType {
+			contentTypes = append(contentTypes, fields[8:8+fieldLength])
+		}
+
+		fields = fields[8+fieldLength:]
+	}
+
+	return binary.BigEndian.Uint32(frame), contentTypes, nil
+}
+
+// control frame: escape (0), length, type and the content type field
+func (el *dnstapWriter) control(controlType uint32, contentType []byte) error {
+	var frame []byte
+
+	frame = appendUint32(frame, controlType)
+	if contentType != nil {
+		frame = appendUint32(frame, kFrameStreamFieldContentType)
+		frame = appendUint32(frame, uint32(len(contentType)))
+		frame = append(frame, contentType...)
+	}
+
+	header := appendUint32(nil, 0)
+	header = appendUint32(header, uint32(len(frame)))
+
+	_, err := el.buffer.Write(append(header, frame...))
+	if err != nil {
+		return err
+	}
+
+	return el.buffer.Flush()
+}
+
+// data frame: length and the protobuf message
+func (el *dnstapWriter) frame(data []byte) error {
+	_, err := el.buffer.Write(append(appendUint32(nil, uint32(len(data))), data...))
+	return err
+}
+
+func (el *dnstapWriter) Write(entry queryLogEntry) {
+	var err error
+
+	if el.writer == nil {
+		// a missing collector is retried from time to time, instead of on each query
+		if time.Since(el.lastFail) < kDnstapReconnectInterval {
+			return
+		}
+
+		err = el.connect()
+		if err != nil {
+			el.lastFail = time.Now()
+			el.onError(err)
+			el.close()
+			return
+		}
+	}
+
+	err = el.frame(el.message(entry, kDnstapMessageTypeClientQuery))
+	if err == nil {
+		err = el.frame(el.message(entry, kDnstapMessageTypeClientAnswer))
+	}
+	if err == nil {
+		err = el.buffer.Flush()
+	}
+	if err != nil {
+		el.lastFail = time.Now()
+		el.onError(err)
+		el.close()
+	}
+}
+
+func (el *dnstapWriter) close() {
+	if el.writer == nil {
+		return
+	}
+
+	if el.buffer != nil {
+		err := el.control(kFrameStreamControlStop, nil)
+
+		// the collector answers STOP with FINISH on sockets
+		if err == nil && el.conn != nil && el.conn.SetDeadline(time.Now().Add(kDnstapHandshakeTimeOut)) == nil {
+			_, _, _ = el.readControl()
+		}
+	}
+
+	_ = el.writer.Close()
+	el.writer = nil
+	el.conn = nil
+	el.buffer = nil
+}
+
+// dnstap.Dnstap message
+func (el *dnstapWriter) message(entry queryLogEntry, messageType uint64) []byte {
+	var data []byte
+	var message []byte
+	var err error
+
+	message = protobufVarint(message, 1, messageType)
+
+	ip, port := remoteAddress(entry.query.RemoteAddr)
+	if ip != nil {
+		if ipv4 := ip.To4(); ipv4 != nil {
+			message = protobufVarint(message, 2, kDnstapSocketFamilyInet)
+			ip = ipv4
+		} else {
+			message = protobufVarint(message, 2, kDnstapSocketFamilyInet6)
+		}
+
+		if _, ok := entry.query.RemoteAddr.(*net.TCPAddr); ok {
+			message = protobufVarint(message, 3, kDnstapSocketProtocolTcp)
+		} else {
+			message = protobufVarint(message, 3, kDnstapSocketProtocolUdp)
+		}
+
+		message = protobufBytes(message, 4, ip)
+		message = protobufVarint(message, 6, uint64(port))
+	}
+
+	message = protobufVarint(message, 8, uint64(entry.start.Unix()))
+	message = protobufFixed32(message, 9, uint32(entry.start.Nanosecond()))
+
+	if messageType == kDnstapMessageTypeClientQuery {
+		data, err = entry.query.Message.Pack(nil, true)
+		if err == nil {
+			message = protobufBytes(message, 10, data)
+		}
+	} else {
+		end := entry.start.Add(entry.latency)
+		message = protobufVarint(message, 12, uint64(end.Unix()))
+		message = protobufFixed32(message, 13, uint32(end.Nanosecond()))
+
+		data, err = entry.response.Pack(nil, true)
+		if err == nil {
+			message = protobufBytes(message, 14, data)
+		}
+	}
+
+	var dnstap []byte
+	if el.identity != "" {
+		dnstap = protobufBytes(dnstap, 1, []byte(el.identity))
+	}
+	dnstap = protobufBytes(dnstap, 14, message)
+	dnstap = protobufVarint(dnstap, 15, kDnstapTypeMessage)
+
+	return dnstap
+}
+
+func appendUint32(b []byte, value uint32) []byte {
+	var buffer [4]byte
+	binary.BigEndian.PutUint32(buffer[:], value)
+	return append(b, buffer[:]...)
+}
+
+func appendUvarint(b []byte, value uint64) []byte {
+	var buffer [binary.MaxVarintLen64]byte
+	return append(b, buffer[:binary.PutUvarint(buffer[:], value)]...)
+}
+
+func protobufKey(b []byte, field, wireType uint64) []byte {
+	return appendUvarint(b, field<<3|wireType)
+}
+
+func protobufVarint(b []byte, field, value uint64) []byte {
+	return appendUvarint(protobufKey(b, field, 0), value)
+}
+
+func protobufFixed32(b []byte, field uint64, value uint32) []byte {
+	var buffer [4]byte
+	binary.LittleEndian.PutUint32(buffer[:], value)
+	return append(protobufKey(b, field, 5), buffer[:]...)
+}
+
+func protobufBytes(b []byte, field uint64, value []byte) []byte {
+	b = appendUvarint(protobufKey(b, field, 2), uint64(len(value)))
+	return append(b, value...)
+}
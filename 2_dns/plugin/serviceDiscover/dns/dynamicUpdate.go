@@ -0,0 +1,551 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/helmutkemper/communsTypesForGolangPlugin"
+	"github.com/helmutkemper/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kDnsOpCodeUpdate = 5
+
+	kDnsRCodeFormErr  = 1
+	kDnsRCodeServFail = 2
+	kDnsRCodeRefused  = 5
+	kDnsRCodeYXDomain = 6
+	kDnsRCodeYXRRSet  = 7
+	kDnsRCodeNXRRSet  = 8
+	kDnsRCodeNotAuth  = 9
+	kDnsRCodeNotZone  = 10
+
+	kDynamicUpdateMaxPacketLength = 65535
+)
+
+// dynamic update configuration
+//
+//   tsigKeys:    keys allowed to sign updates, by key name. ex.: {"update.key.": {"algorithm": "hmac-sha256",
+//                "secret": "base64..."}}
+//   allow:       networks allowed to send updates. empty allows any network with a valid key
+//   fudgeSecond: accepted difference between the time of the update and the time of the server. default 300
+type dynamicUpdateConfig struct {
+	TsigKeys    map[string]tsigKeyConfig
+	Allow       []string
+	FudgeSecond int
+}
+
+var errServiceAlias = errors.New("the name is an alias")
+
+// access to the records saved by the host data plugin, under the same keys used by the http server plugin
+type serviceStorage struct {
+	sync.RWMutex
+	get         func([]byte) (error, int, []communsTypes.KeyValueType)
+	transaction func([]communsTypes.KeyValueType, [][]byte) error
+	keyPrefix   string
+	// names saved by the http server plugin, by the lower case name of the zone. ex.: "node": "Node"
+	names map[string]string
+}
+
+func (el *serviceStorage) ready() bool {
+	return el.get != nil && el.transaction != nil
+}
+
+// keep the name saved in the data plugin, so an update of "node" changes the service saved as "Node"
+func (el *serviceStorage) setName(serviceName string) {
+	el.Lock()
+	defer el.Unlock()
+
+	if el.names == nil {
+		el.names = make(map[string]string)
+	}
+
+	el.names[strings.ToLower(serviceName)] = serviceName
+}
+
+func (el *serviceStorage) removeName(serviceName string) {
+	el.Lock()
+	defer el.Unlock()
+
+	delete(el.names, strings.ToLower(serviceName))
+}
+
+// key of the service in the data plugin. Names not saved yet are saved in lower case, as served by the zone
+func (el *serviceStorage) key(serviceName string) []byte {
+	el.RLock()
+	defer el.RUnlock()
+
+	if name, ok := el.names[serviceName]; ok {
+		return []byte(el.keyPrefix + name)
+	}
+
+	return []byte(el.keyPrefix + serviceName)
+}
+
+// records of the service. Returns errServiceAlias when the name is saved as an alias
+func (el *serviceStorage) load(serviceName string) ([]serviceRecord, error) {
+	var records []serviceRecord
+
+	err, found, data := el.get(el.key(serviceName))
+	if err != nil || found == 0 {
+		return nil, err
+	}
+
+	for k := range data {
+		var alias serviceAlias
+		if json.Unmarshal(data[k].V, &alias) == nil && alias.Alias != "" {
+			return nil, errServiceAlias
+		}
+
+		err = json.Unmarshal(data[k].V, &records)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// saves the records of the services in one transaction, so all of them are saved or none. Services without records
+// are deleted
+func (el *serviceStorage) save(services map[string][]serviceRecord) error {
+	var put []communsTypes.KeyValueType
+	var del [][]byte
+
+	for serviceName, records := range services {
+		var err error
+		var toSave communsTypes.KeyValueType
+
+		if len(records) == 0 {
+			del = append(del, el.key(serviceName))
+			continue
+		}
+
+		toSave.K = el.key(serviceName)
+		toSave.V, err = json.Marshal(&records)
+		if err != nil {
+			return err
+		}
+
+		put = append(put, toSave)
+	}
+
+	if len(put) == 0 && len(del) == 0 {
+		return nil
+	}
+
+	return el.transaction(put, del)
+}
+
+// dynamicUpdate accepts RFC 2136 UPDATE messages signed with one of the TSIG keys of the configuration.
+// Only SRV records of the service zone can be added or deleted; they are saved by the data plugin, as the http server
+// plugin does, and reach the zone by the watch of the host. Updates are accepted over udp only.
+type dynamicUpdate struct {
+	sync.Mutex
+	keys    map[string]tsigKey
+	allow   []*net.IPNet
+	fudge   time.Duration
+	zone    *serviceZone
+	storage *serviceStorage
+	onError func(error)
+	onLog   func(string)
+}
+
+func newDynamicUpdate(config dynamicUpdateConfig, zone *serviceZone, storage *serviceStorage, onError func(error), onLog func(string)) (*dynamicUpdate, error) {
+	var update = &dynamicUpdate{
+		keys:    make(map[string]tsigKey),
+		fudge:   time.Duration(config.FudgeSecond) * time.Second,
+		zone:    zone,
+		storage: storage,
+		onError: onError,
+		onLog:   onLog,
+	}
+
+	if len(config.TsigKeys) == 0 {
+		return nil, errors.New("dynamic update needs at least one tsig key")
+	}
+
+	if update.fudge == 0 {
+		update.fudge = kTsigDefaultFudgeSecond * time.Second
+	}
+
+	for name, keyConfig := range config.TsigKeys {
+		key, err := newTsigKey(name, keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		update.keys[key.name] = key
+	}
+
+	for _, cidr := range config.Allow {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		update.allow = append(update.allow, network)
+	}
+
+	return update, nil
+}
+
+func isUpdatePacket(packet []byte) bool {
+	return len(packet) >= kDnsHeaderLength && packet[2]&0x80 == 0 && (packet[2]>>3)&0x0f == kDnsOpCodeUpdate
+}
+
+func (el *dynamicUpdate) allowed(addr net.Addr) bool {
+	if len(el.allow) == 0 {
+		return true
+	}
+
+	ip, _ := remoteAddress(addr)
+	for _, network := range el.allow {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// response to the UPDATE message, signed when the message was signed by a valid key
+func (el *dynamicUpdate) Serve(message []byte, addr net.Addr) []byte {
+	var err error
+	var offset int
+	var zoneName string
+	var zoneType dns.Type
+	var prerequisites, updates, additionals []wireResource
+	var tsig tsigRecord
+	var key tsigKey
+	var rcode byte
+
+	if len(message) < kDnsHeaderLength {
+		return nil
+	}
+
+	if binary.BigEndian.Uint16(message[4:]) != 1 {
+		return updateResponse(message, kDnsHeaderLength, kDnsRCodeFormErr)
+	}
+
+	zoneName, zoneType, _, offset, err = wireReadQuestion(message, kDnsHeaderLength)
+	if err != nil {
+		return updateResponse(message, kDnsHeaderLength, kDnsRCodeFormErr)
+	}
+	zoneEnd := offset
+
+	prerequisites, offset, err = wireReadResourceList(message, offset, int(binary.BigEndian.Uint16(message[6:])))
+	if err == nil {
+		updates, offset, err = wireReadResourceList(message, offset, int(binary.BigEndian.Uint16(message[8:])))
+	}
+	if err == nil {
+		additionals, _, err = wireReadResourceList(message, offset, int(binary.BigEndian.Uint16(message[10:])))
+	}
+	if err != nil {
+		return updateResponse(message, zoneEnd, kDnsRCodeFormErr)
+	}
+
+	if len(additionals) == 0 || additionals[len(additionals)-1].rrType != kTypeTSIG || !el.allowed(addr) {
+		el.onLog(fmt.Sprintf("unsigned or not allowed dynamic update refused to %v", addr))
+		return updateResponse(message, zoneEnd, kDnsRCodeRefused)
+	}
+
+	tsig, err = parseTsig(message, additionals[len(additionals)-1])
+	if err != nil {
+		return updateResponse(message, zoneEnd, kDnsRCodeFormErr)
+	}
+
+	key, err = verifyTsig(message, tsig, el.keys, el.fudge, time.Now())
+	if err != nil {
+		el.onLog(fmt.Sprintf("dynamic update of %v from %v not authorized: %v", zoneName, addr, err))
+		return updateResponse(message, zoneEnd, kDnsRCodeNotAuth)
+	}
+
+	if zoneType != dns.TypeSOA || zoneName != el.zone.Origin() {
+		rcode = kDnsRCodeNotAuth
+	} else {
+		rcode = el.apply(message, prerequisites, updates)
+	}
+
+	if rcode == 0 {
+		el.onLog(fmt.Sprintf("dynamic update of %v by key %v from %v: %v changes", zoneName, key.name, addr, len(updates)))
+	}
+
+	response := updateResponse(message, zoneEnd, rcode)
+
+	// the response to an EDNS0 update has an OPT record, added here because the TSIG record must be the last one, RFC
+	// 8945 section 5.1. ednsPacketConn doesn't add another one
+	if converted, ok := addr.(*ednsAddr); ok {
+		response = appendOpt(response, uint16(converted.udpPayloadSize), nil)
+	}
+
+	return signTsig(response, key, tsig.mac, time.Now())
+}
+
+// header and zone section of the request with the response flags and rcode
+func updateResponse(message []byte, zoneEnd int, rcode byte) []byte {
+	var response = append([]byte{}, message[:zoneEnd]...)
+
+	// QR flag and opcode UPDATE
+	response[2] = 0x80 | kDnsOpCodeUpdate<<3
+	response[3] = rcode
+
+	if zoneEnd == kDnsHeaderLength {
+		response[4], response[5] = 0, 0
+	}
+	for k := 6; k < kDnsHeaderLength; k++ {
+		response[k] = 0
+	}
+
+	return response
+}
+
+// check the prerequisites and apply the updates as one change, RFC 2136 sections 3.2 and 3.4
+func (el *dynamicUpdate) apply(message []byte, prerequisites, updates []wireResource) byte {
+	var err error
+	var services = make(map[string][]serviceRecord)
+	var changed = make(map[string]bool)
+	var expected = make(map[string][]serviceRecord)
+
+	if !el.storage.ready() {
+		el.onError(errors.New("dynamic update needs the data plugin functions"))
+		return kDnsRCodeServFail
+	}
+
+	el.Lock()
+	defer el.Unlock()
+
+	load := func(fqdn string) ([]serviceRecord, byte) {
+		serviceName, ok := el.zone.serviceName(fqdn)
+		if !ok || serviceName == "" {
+			return nil, kDnsRCodeNotZone
+		}
+
+		if _, ok = services[serviceName]; !ok {
+			services[serviceName], err = el.storage.load(serviceName)
+			if err == errServiceAlias {
+				el.onLog(fmt.Sprintf("dynamic update of the alias %v refused", fqdn))
+				return nil, kDnsRCodeRefused
+			}
+			if err != nil {
+				el.onError(err)
+				return nil, kDnsRCodeServFail
+			}
+		}
+
+		return services[serviceName], 0
+	}
+
+	for _, resource := range prerequisites {
+		records, rcode := load(resource.name)
+		if rcode != 0 {
+			return rcode
+		}
+
+		switch {
+		case resource.ttl != 0:
+			return kDnsRCodeFormErr
+
+		case resource.class == kDnsClassANY && resource.rrType == kTypeANY:
+			if len(records) == 0 {
+				return kDnsRCodeNXDomain
+			}
+
+		case resource.class == kDnsClassANY:
+			if !serviceHasType(records, resource.rrType) {
+				return kDnsRCodeNXRRSet
+			}
+
+		case resource.class == kDnsClassNONE && resource.rrType == kTypeANY:
+			if len(records) != 0 {
+				return kDnsRCodeYXDomain
+			}
+
+		case resource.class == kDnsClassNONE:
+			if serviceHasType(records, resource.rrType) {
+				return kDnsRCodeYXRRSet
+			}
+
+		case resource.class == uint16(dns.ClassIN) && resource.rrType == dns.TypeSRV:
+			srv, err := wireReadSRV(message, resource)
+			if err != nil {
+				return kDnsRCodeFormErr
+			}
+			expected[resource.name] = append(expected[resource.name], serviceRecordFromSRV(srv))
+
+		case resource.class == uint16(dns.ClassIN):
+			return kDnsRCodeNXRRSet
+
+		default:
+			return kDnsRCodeFormErr
+		}
+	}
+
+	// value dependent prerequisites: the SRV set must be exactly the one of the message
+	for fqdn, records := range expected {
+		current, _ := load(fqdn)
+		if !sameServiceRecords(current, records) {
+			return kDnsRCodeNXRRSet
+		}
+	}
+
+	// all updates are checked before the first one is applied
+	for _, resource := range updates {
+		if _, rcode := load(resource.name); rcode != 0 {
+			return rcode
+		}
+
+		switch {
+		case resource.class == uint16(dns.ClassIN) && resource.rrType == dns.TypeSRV:
+		case resource.class == kDnsClassANY && (resource.rrType == kTypeANY || resource.rrType == dns.TypeSRV):
+			if resource.ttl != 0 || len(resource.rdata) != 0 {
+				return kDnsRCodeFormErr
+			}
+		case resource.class == kDnsClassNONE && resource.rrType == dns.TypeSRV:
+			if resource.ttl != 0 {
+				return kDnsRCodeFormErr
+			}
+		case resource.class == uint16(dns.ClassIN) || resource.class == kDnsClassANY || resource.class == kDnsClassNONE:
+			// only SRV records are saved by the data plugin
+			return kDnsRCodeRefused
+		default:
+			return kDnsRCodeFormErr
+		}
+	}
+
+	for _, resource := range updates {
+		serviceName, _ := el.zone.serviceName(resource.name)
+		records := services[serviceName]
+
+		if resource.class == kDnsClassANY {
+			services[serviceName] = nil
+			changed[serviceName] = true
+			continue
+		}
+
+		srv, err := wireReadSRV(message, resource)
+		if err != nil {
+			return kDnsRCodeFormErr
+		}
+		record := serviceRecordFromSRV(srv)
+
+		if resource.class == kDnsClassNONE {
+			for k := range records {
+				if records[k].Port == record.Port && records[k].Target == record.Target &&
+					records[k].Priority == record.Priority && records[k].Weight == record.Weight {
+					services[serviceName] = append(records[:k], records[k+1:]...)
+					changed[serviceName] = true
+					break
+				}
+			}
+			continue
+		}
+
+		found := false
+		for k := range records {
+			if records[k].Port == record.Port && records[k].Target == record.Target {
+				records[k].Priority = record.Priority
+				records[k].Weight = record.Weight
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			services[serviceName] = append(records, record)
+		}
+		changed[serviceName] = true
+	}
+
+	var toSave = make(map[string][]serviceRecord)
+	for serviceName := range changed {
+		toSave[serviceName] = services[serviceName]
+	}
+
+	err = el.storage.save(toSave)
+	if err != nil {
+		el.onError(err)
+		return kDnsRCodeServFail
+	}
+
+	return 0
+}
+
+func serviceRecordFromSRV(srv *dns.SRV) serviceRecord {
+	return serviceRecord{
+		Priority: srv.Priority,
+		Weight:   srv.Weight,
+		Port:     srv.Port,
+		Target:   srv.Target,
+	}
+}
+
+// true if the records of a service are served with the type
+func serviceHasType(records []serviceRecord, rrType dns.Type) bool {
+	switch rrType {
+	case dns.TypeSRV:
+		return len(records) != 0
+	case dns.TypeTXT:
+		for _, record := range records {
+			if len(record.Metadata) != 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func sameServiceRecords(current, expected []serviceRecord) bool {
+	if len(current) != len(expected) {
+		return false
+	}
+
+	for _, record := range expected {
+		found := false
+		for _, currentRecord := range current {
+			if currentRecord.Port == record.Port && currentRecord.Target == record.Target &&
+				currentRecord.Priority == record.Priority && currentRecord.Weight == record.Weight {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// udp connection that answers UPDATE messages before they reach the DNS server
+type updatePacketConn struct {
+	net.PacketConn
+	update *dynamicUpdate
+}
+
+func (el *updatePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := el.PacketConn.ReadFrom(b)
+		if err != nil || !isUpdatePacket(b[:n]) {
+			return n, addr, err
+		}
+
+		message := append([]byte{}, b[:n]...)
+		go func() {
+			response := el.update.Serve(message, addr)
+			if response == nil || len(response) > kDynamicUpdateMaxPacketLength {
+				return
+			}
+
+			_, err := el.PacketConn.WriteTo(response, addr)
+			if err != nil {
+				el.update.onError(err)
+			}
+		}()
+	}
+}
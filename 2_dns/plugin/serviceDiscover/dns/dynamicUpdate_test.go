@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/helmutkemper/communsTypesForGolangPlugin"
+	"github.com/helmutkemper/dns"
+	"net"
+	"testing"
+	"time"
+)
+
+// data plugin of the tests, with the count of transactions
+type testData struct {
+	values       map[string][]byte
+	transactions int
+	fail         bool
+}
+
+func (el *testData) get(key []byte) (error, int, []communsTypes.KeyValueType) {
+	value, ok := el.values[string(key)]
+	if !ok {
+		return nil, 0, nil
+	}
+
+	return nil, 1, []communsTypes.KeyValueType{{K: key, V: value}}
+}
+
+func (el *testData) transaction(put []communsTypes.KeyValueType, del [][]byte) error {
+	el.transactions++
+	if el.fail {
+		return errors.New("transaction error")
+	}
+
+	for _, value := range put {
+		el.values[string(value.K)] = value.V
+	}
+
+	for _, key := range del {
+		delete(el.values, string(key))
+	}
+
+	return nil
+}
+
+func (el *testData) records(t *testing.T, key string) []serviceRecord {
+	var records []serviceRecord
+
+	if value, ok := el.values[key]; ok {
+		if err := json.Unmarshal(value, &records); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return records
+}
+
+func testDynamicUpdate(t *testing.T, values map[string]string) (*dynamicUpdate, *testData, tsigKey) {
+	var data = &testData{values: make(map[string][]byte)}
+
+	for key, value := range values {
+		data.values[key] = []byte(value)
+	}
+
+	storage := &serviceStorage{get: data.get, transaction: data.transaction, keyPrefix: "svc/"}
+	key := testTsigKey(t)
+
+	update, err := newDynamicUpdate(dynamicUpdateConfig{
+		TsigKeys: map[string]tsigKeyConfig{"update.key": {Algorithm: "hmac-sha256", Secret: "c2VjcmV0IG9mIHRoZSB1cGRhdGUga2V5"}},
+	}, testZone(), storage, func(err error) {}, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return update, data, key
+}
+
+func testSRVData(priority, weight, port uint16, target string) []byte {
+	var rdata []byte
+
+	rdata = wireAppendUint16(rdata, priority)
+	rdata = wireAppendUint16(rdata, weight)
+	rdata = wireAppendUint16(rdata, port)
+
+	return wireAppendName(rdata, target)
+}
+
+func testUpdateServe(t *testing.T, update *dynamicUpdate, key tsigKey, prerequisites, updates []testResource) byte {
+	message := signTsig(testUpdateMessage("tld.", prerequisites, updates), key, nil, time.Now())
+
+	response := update.Serve(message, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+	if len(response) < kDnsHeaderLength {
+		t.Fatalf("response error, get=%v", response)
+	}
+
+	return response[3] & 0x0f
+}
+
+func testRCode(t *testing.T, get, want byte) {
+	if get != want {
+		t.Errorf("rcode error, want=%v, get=%v", want, get)
+	}
+}
+
+func TestDynamicUpdateAdd(t *testing.T) {
+	update, data, key := testDynamicUpdate(t, nil)
+
+	rcode := testUpdateServe(t, update, key, nil, []testResource{
+		{name: "node.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 8080, "node1.tld.")},
+		{name: "node.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 8081, "node1.tld.")},
+	})
+	testRCode(t, rcode, 0)
+
+	records := data.records(t, "svc/node")
+	if len(records) != 2 || records[0].Port != 8080 || records[0].Target != "node1.tld." || records[0].Priority != 10 || records[0].Weight != 20 {
+		t.Errorf("saved records error, get=%+v", records)
+	}
+
+	if data.transactions != 1 {
+		t.Errorf("transaction error, want=1, get=%v", data.transactions)
+	}
+}
+
+func TestDynamicUpdateDelete(t *testing.T) {
+	update, data, key := testDynamicUpdate(t, map[string]string{
+		"svc/node": `[{"Priority":10,"Weight":20,"Port":8080,"Target":"node1.tld."},{"Priority":10,"Weight":20,"Port":8081,"Target":"node1.tld."}]`,
+		"svc/old":  `[{"Priority":10,"Weight":20,"Port":8080,"Target":"node1.tld."}]`,
+	})
+
+	rcode := testUpdateServe(t, update, key, nil, []testResource{
+		{name: "node.tld.", rrType: dns.TypeSRV, class: kDnsClassNONE, rdata: testSRVData(10, 20, 8081, "node1.tld.")},
+		{name: "old.tld.", rrType: kTypeANY, class: kDnsClassANY},
+	})
+	testRCode(t, rcode, 0)
+
+	if records := data.records(t, "svc/node"); len(records) != 1 || records[0].Port != 8080 {
+		t.Errorf("saved records error, get=%+v", records)
+	}
+
+	if _, ok := data.values["svc/old"]; ok {
+		t.Errorf("service old must be deleted")
+	}
+}
+
+// the prerequisites are checked before any update, RFC 2136 section 3.2
+func TestDynamicUpdatePrerequisites(t *testing.T) {
+	node := `[{"Priority":10,"Weight":20,"Port":8080,"Target":"node1.tld."}]`
+	add := []testResource{{name: "new.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 80, "node1.tld.")}}
+
+	var tests = []struct {
+		name          string
+		prerequisites []testResource
+		rcode         byte
+	}{
+		{"name in use", []testResource{{name: "node.tld.", rrType: kTypeANY, class: kDnsClassANY}}, 0},
+		{"name in use, missing", []testResource{{name: "missing.tld.", rrType: kTypeANY, class: kDnsClassANY}}, kDnsRCodeNXDomain},
+		{"rrset exists", []testResource{{name: "node.tld.", rrType: dns.TypeSRV, class: kDnsClassANY}}, 0},
+		{"rrset exists, missing", []testResource{{name: "missing.tld.", rrType: dns.TypeSRV, class: kDnsClassANY}}, kDnsRCodeNXRRSet},
+		{"name not in use", []testResource{{name: "node.tld.", rrType: kTypeANY, class: kDnsClassNONE}}, kDnsRCodeYXDomain},
+		{"rrset doesn't exist", []testResource{{name: "node.tld.", rrType: dns.TypeSRV, class: kDnsClassNONE}}, kDnsRCodeYXRRSet},
+		{"rrset value", []testResource{{name: "node.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), rdata: testSRVData(10, 20, 8080, "node1.tld.")}}, 0},
+		{"rrset value, other", []testResource{{name: "node.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), rdata: testSRVData(10, 20, 9090, "node1.tld.")}}, kDnsRCodeNXRRSet},
+		{"ttl", []testResource{{name: "node.tld.", rrType: kTypeANY, class: kDnsClassANY, ttl: 60}}, kDnsRCodeFormErr},
+		{"out of the zone", []testResource{{name: "node.other.", rrType: kTypeANY, class: kDnsClassANY}}, kDnsRCodeNotZone},
+	}
+
+	for _, test := range tests {
+		update, data, key := testDynamicUpdate(t, map[string]string{"svc/node": node})
+
+		rcode := testUpdateServe(t, update, key, test.prerequisites, add)
+		if rcode != test.rcode {
+			t.Errorf("%v: rcode error, want=%v, get=%v", test.name, test.rcode, rcode)
+		}
+
+		if _, saved := data.values["svc/new"]; saved != (test.rcode == 0) {
+			t.Errorf("%v: update error, saved=%v", test.name, saved)
+		}
+	}
+}
+
+func TestDynamicUpdateRefusedType(t *testing.T) {
+	update, data, key := testDynamicUpdate(t, nil)
+
+	rcode := testUpdateServe(t, update, key, nil, []testResource{
+		{name: "node.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 8080, "node1.tld.")},
+		{name: "node.tld.", rrType: dns.TypeA, class: uint16(dns.ClassIN), ttl: 60, rdata: []byte{10, 0, 0, 1}},
+	})
+	testRCode(t, rcode, kDnsRCodeRefused)
+
+	if data.transactions != 0 {
+		t.Errorf("nothing must be saved, get=%v transactions", data.transactions)
+	}
+}
+
+// a failed save answers SERVFAIL and, as the update is one transaction, nothing is saved
+func TestDynamicUpdateServFail(t *testing.T) {
+	update, data, key := testDynamicUpdate(t, nil)
+	data.fail = true
+
+	rcode := testUpdateServe(t, update, key, nil, []testResource{
+		{name: "a.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 8080, "node1.tld.")},
+		{name: "b.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 8080, "node1.tld.")},
+	})
+	testRCode(t, rcode, kDnsRCodeServFail)
+
+	if data.transactions != 1 || len(data.values) != 0 {
+		t.Errorf("transaction error, get=%v transactions and %v values", data.transactions, len(data.values))
+	}
+}
+
+func TestDynamicUpdateAlias(t *testing.T) {
+	update, data, key := testDynamicUpdate(t, map[string]string{"svc/db": `{"Alias":"node"}`})
+
+	rcode := testUpdateServe(t, update, key, nil, []testResource{
+		{name: "db.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 8080, "node1.tld.")},
+	})
+	testRCode(t, rcode, kDnsRCodeRefused)
+
+	if string(data.values["svc/db"]) != `{"Alias":"node"}` {
+		t.Errorf("alias must be kept, get=%s", data.values["svc/db"])
+	}
+}
+
+// services saved by the http server plugin with upper case letters are changed under the same key
+func TestDynamicUpdateMixedCaseName(t *testing.T) {
+	update, data, key := testDynamicUpdate(t, map[string]string{"svc/Node": `[{"Priority":10,"Weight":20,"Port":8080,"Target":"node1.tld."}]`})
+	update.storage.setName("Node")
+
+	rcode := testUpdateServe(t, update, key, nil, []testResource{
+		{name: "NODE.tld.", rrType: dns.TypeSRV, class: uint16(dns.ClassIN), ttl: 60, rdata: testSRVData(10, 20, 8081, "node1.tld.")},
+	})
+	testRCode(t, rcode, 0)
+
+	if records := data.records(t, "svc/Node"); len(records) != 2 {
+		t.Errorf("saved records error, get=%+v", records)
+	}
+
+	if _, ok := data.values["svc/node"]; ok {
+		t.Errorf("service must not be saved twice")
+	}
+}
+
+func TestDynamicUpdateUnsigned(t *testing.T) {
+	update, _, _ := testDynamicUpdate(t, nil)
+
+	response := update.Serve(testUpdateMessage("tld.", nil, nil), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	testRCode(t, response[3]&0x0f, kDnsRCodeRefused)
+}
+
+func TestDynamicUpdateNotAuth(t *testing.T) {
+	update, _, _ := testDynamicUpdate(t, nil)
+
+	other, err := newTsigKey("update.key", tsigKeyConfig{Algorithm: "hmac-sha256", Secret: "b3RoZXIgc2VjcmV0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := update.Serve(signTsig(testUpdateMessage("tld.", nil, nil), other, nil, time.Now()), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	testRCode(t, response[3]&0x0f, kDnsRCodeNotAuth)
+}
+
+// the response to an EDNS0 update has the OPT record before the TSIG record, that is the last one
+func TestDynamicUpdateEdnsResponse(t *testing.T) {
+	update, _, key := testDynamicUpdate(t, nil)
+	request := signTsig(testUpdateMessage("tld.", nil, nil), key, nil, time.Now())
+
+	response := update.Serve(request, &ednsAddr{Addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, udpPayloadSize: 1232})
+	tsig := testReadTsig(t, response)
+
+	if binary.BigEndian.Uint16(response[10:]) != 2 || !packetHasOpt(response) {
+		t.Fatalf("additional section error, want OPT and TSIG")
+	}
+
+	expected := tsigMAC(key, testReadTsig(t, request).mac, tsig.unsignedMessage(response), tsig.variables())
+	if string(expected) != string(tsig.mac) {
+		t.Errorf("response signature error")
+	}
+
+	// ednsPacketConn keeps the response as it is
+	conn := &testPacketConn{}
+	_, _ = (&ednsPacketConn{PacketConn: conn, udpPayloadSize: 1232}).WriteTo(response, &ednsAddr{Addr: &net.UDPAddr{}, udpPayloadSize: 1232})
+	if string(conn.written) != string(response) {
+		t.Errorf("edns connection changed the signed response")
+	}
+}
+
+// keeps the last packet written
+type testPacketConn struct {
+	net.PacketConn
+	written []byte
+}
+
+func (el *testPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	el.written = append([]byte{}, b...)
+	return len(b), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/helmutkemper/dns"
+	"net"
+)
+
+const (
+	kEdnsMinUdpPayloadSize     = 512
+	kEdnsDefaultUdpPayloadSize = 1232
+)
+
+// EDNS0 configuration
+//
+//   udpPayloadSize: largest udp response sent to clients with EDNS0, RFC 6891. The client buffer size is used when it
+//                   is smaller. Clients without EDNS0 get 512 bytes. default 1232, the size that avoids ip
+//                   fragmentation
+//   maxAnswers:     largest number of records of each type in the answer. Services with more instances are answered
+//                   with a random subset of them, different in each query. default 0, all instances
+type ednsConfig struct {
+	UdpPayloadSize int
+	MaxAnswers     int
+}
+
+func newEdnsConfig(config ednsConfig) (ednsConfig, error) {
+	if config.UdpPayloadSize == 0 {
+		config.UdpPayloadSize = kEdnsDefaultUdpPayloadSize
+	}
+
+	if config.UdpPayloadSize < kEdnsMinUdpPayloadSize || config.UdpPayloadSize > 65535 {
+		return config, errors.New("edns udpPayloadSize must be between 512 and 65535")
+	}
+
+	if config.MaxAnswers < 0 {
+		return config, errors.New("edns maxAnswers must be a positive number or zero")
+	}
+
+	return config, nil
+}
+
+// address of a udp query with an OPT record
+type ednsAddr struct {
+	net.Addr
+	udpPayloadSize int
+}
+
+// udp connection that keeps the buffer size of the EDNS0 queries and truncates the responses larger than the client
+// can receive, so the client retries over tcp
+type ednsPacketConn struct {
+	net.PacketConn
+	udpPayloadSize int
+}
+
+func (el *ednsPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := el.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+
+	size, ok := packetUdpPayloadSize(b[:n])
+	if !ok {
+		return n, addr, err
+	}
+
+	if size < kEdnsMinUdpPayloadSize {
+		size = kEdnsMinUdpPayloadSize
+	}
+
+	if size > el.udpPayloadSize {
+		size = el.udpPayloadSize
+	}
+
+	return n, &ednsAddr{Addr: addr, udpPayloadSize: size}, nil
+}
+
+func (el *ednsPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	var response = b
+	var size = kEdnsMinUdpPayloadSize
+
+	converted, ok := addr.(*ednsAddr)
+	if ok {
+		size = converted.udpPayloadSize
+		addr = converted.Addr
+
+		// the response to an EDNS0 query has an OPT record too, RFC 6891 section 7
+		if !packetHasOpt(response) {
+			response = appendOpt(response, uint16(el.udpPayloadSize), nil)
+		}
+	}
+
+	if len(response) > size {
+		response = truncatePacket(response)
+		if ok {
+			response = appendOpt(response, uint16(el.udpPayloadSize), nil)
+		}
+	}
+
+	_, err := el.PacketConn.WriteTo(response, addr)
+	return len(b), err
+}
+
+// buffer size of the OPT record of a query, RFC 6891 section 6.2.3
+func packetUdpPayloadSize(packet []byte) (int, bool) {
+	resources, ok := packetResources(packet)
+	if !ok {
+		return 0, false
+	}
+
+	for _, resource := range resources {
+		if resource.rrType == dns.TypeOPT {
+			return int(resource.class), true
+		}
+	}
+
+	return 0, false
+}
+
+func packetHasOpt(packet []byte) bool {
+	resources, ok := packetResources(packet)
+	if !ok {
+		return false
+	}
+
+	for _, resource := range resources {
+		if resource.rrType == dns.TypeOPT {
+			return true
+		}
+	}
+
+	return false
+}
+
+// packet with one more additional record: an OPT record with the buffer size and the options data
+func appendOpt(packet []byte, udpPayloadSize uint16, data []byte) []byte {
+	if len(packet) < kDnsHeaderLength {
+		return packet
+	}
+
+	packet = append([]byte{}, packet...)
+	packet = append(packet, 0)
+	packet = wireAppendUint16(packet, uint16(dns.TypeOPT))
+	packet = wireAppendUint16(packet, udpPayloadSize)
+	packet = wireAppendUint32(packet, 0)
+	packet = wireAppendUint16(packet, uint16(len(data)))
+	packet = append(packet, data...)
+	binary.BigEndian.PutUint16(packet[10:], binary.BigEndian.Uint16(packet[10:])+1)
+
+	return packet
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/helmutkemper/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kHealthCheckTypeTcp  = "tcp"
+	kHealthCheckTypeHttp = "http"
+	kHealthCheckTypeGrpc = "grpc"
+
+	kHealthCheckDefaultIntervalMillisecond = 5000
+	kHealthCheckDefaultTimeOutMillisecond  = 1000
+	kHealthCheckDefaultFailureThreshold    = 3
+	kHealthCheckDefaultSuccessThreshold    = 1
+)
+
+// health check configuration of one service
+//
+//   type:    "tcp", "http" or "grpc"
+//   path:    http only. path used by GET. ex.: "/health"
+//   service: grpc only. service name sent to grpc.health.v1.Health/Check. blank means the whole server
+type healthCheckServiceConfig struct {
+	Type    string
+	Path    string
+	Service string
+}
+
+type healthCheckConfig struct {
+	IntervalMillisecond int
+	TimeOutMillisecond  int
+	FailureThreshold    int
+	SuccessThreshold    int
+	Services            map[string]healthCheckServiceConfig
+}
+
+// health state of one SRV target
+type healthState struct {
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// healthChecker checks, on an interval, every SRV target of the configured services and marks the target down after
+// FailureThreshold consecutive failures and up again after SuccessThreshold consecutive successes.
+// A target that goes down or up changes the records served by the zone, so the change listeners of the zone are called,
+// as after a change of the records: the serial is incremented and the secondary servers are notified.
+type healthChecker struct {
+	sync.RWMutex
+	config  healthCheckConfig
+	zone    *serviceZone
+	state   map[string]*healthState
+	onError func(error)
+	onLog   func(string)
+}
+
+func newHealthChecker(config healthCheckConfig, zone *serviceZone, onError func(error), onLog func(string)) (*healthChecker, error) {
+	if config.IntervalMillisecond == 0 {
+		config.IntervalMillisecond = kHealthCheckDefaultIntervalMillisecond
+	}
+
+	if config.TimeOutMillisecond == 0 {
+		config.TimeOutMillisecond = kHealthCheckDefaultTimeOutMillisecond
+	}
+
+	if config.FailureThreshold == 0 {
+		config.FailureThreshold = kHealthCheckDefaultFailureThreshold
+	}
+
+	if config.SuccessThreshold == 0 {
+		config.SuccessThreshold = kHealthCheckDefaultSuccessThreshold
+	}
+
+	for serviceName, service := range config.Services {
+		switch service.Type {
+		case kHealthCheckTypeTcp, kHealthCheckTypeHttp, kHealthCheckTypeGrpc:
+		default:
+			return nil, errors.New("health check type of service " + serviceName + " must be tcp, http or grpc")
+		}
+	}
+
+	return &healthChecker{
+		config:  config,
+		zone:    zone,
+		state:   make(map[string]*healthState),
+		onError: onError,
+		onLog:   onLog,
+	}, nil
+}
+
+// key used to identify a SRV target of a service. ex.: "node/192.168.0.1:8080"
+func (el *healthChecker) key(serviceName string, srv *dns.SRV) string {
+	return strings.ToLower(serviceName) + "/" + el.address(srv)
+}
+
+// SRV target as host:port, without the final point of the fqdn
+func (el *healthChecker) address(srv *dns.SRV) string {
+	return net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(srv.Port))
+}
+
+// recordFilter used by the zone. Only SRV records of checked services, and the TXT records of their instances, can
+// be marked down; unknown targets are healthy until the first check proves otherwise.
+func (el *healthChecker) IsHealthy(query *dns.Query, serviceName string, record dns.Record) bool {
+	var srv *dns.SRV
+
+	switch converted := record.(type) {
+	case *dns.SRV:
+		srv = converted
+	case *dns.TXT:
+		target, port, ok := txtInstance(converted)
+		if !ok {
+			return true
+		}
+		srv = &dns.SRV{Target: target, Port: port}
+	default:
+		return true
+	}
+
+	el.RLock()
+	defer el.RUnlock()
+
+	state, ok := el.state[el.key(serviceName, srv)]
+	if !ok {
+		return true
+	}
+
+	return state.healthy
+}
+
+// run the checks forever
+func (el *healthChecker) Run() {
+	ticker := time.NewTicker(time.Duration(el.config.IntervalMillisecond) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		el.checkAll()
+	}
+}
+
+func (el *healthChecker) checkAll() {
+	var wg sync.WaitGroup
+	var inUse = make(map[string]bool)
+	var changed = make(chan bool, 1)
+
+	for serviceName, service := range el.config.Services {
+		for _, record := range el.zone.Get(serviceName)[dns.TypeSRV] {
+			srv, ok := record.(*dns.SRV)
+			if !ok {
+				continue
+			}
+
+			key := el.key(serviceName, srv)
+			inUse[key] = true
+
+			wg.Add(1)
+			go func(key, address string, service healthCheckServiceConfig) {
+				defer wg.Done()
+				if el.setResult(key, el.check(address, service)) {
+					select {
+					case changed <- true:
+					default:
+					}
+				}
+			}(key, el.address(srv), service)
+		}
+	}
+
+	wg.Wait()
+
+	if len(changed) != 0 {
+		el.zone.changed()
+	}
+
+	// targets removed from the zone don't need a state anymore
+	el.Lock()
+	for key := range el.state {
+		if !inUse[key] {
+			delete(el.state, key)
+		}
+	}
+	el.Unlock()
+}
+
+// keep the result of a check. Returns true when the target goes down or up
+func (el *healthChecker) setResult(key string, err error) bool {
+	el.Lock()
+	defer el.Unlock()
+
+	state, ok := el.state[key]
+	if !ok {
+		state = &healthState{healthy: true}
+		el.state[key] = state
+	}
+
+	if err != nil {
+		state.successes = 0
+		state.failures++
+		if state.healthy && state.failures >= el.config.FailureThreshold {
+			state.healthy = false
+			el.onLog(fmt.Sprintf("health check: %v is down: %v", key, err))
+			return true
+		}
+		return false
+	}
+
+	state.failures = 0
+	state.successes++
+	if !state.healthy && state.successes >= el.config.SuccessThreshold {
+		state.healthy = true
+		el.onLog(fmt.Sprintf("health check: %v is up", key))
+		return true
+	}
+
+	return false
+}
+
+func (el *healthChecker) check(address string, service healthCheckServiceConfig) error {
+	timeOut := time.Duration(el.config.TimeOutMillisecond) * time.Millisecond
+
+	switch service.Type {
+	case kHealthCheckTypeHttp:
+		return el.checkHttp(address, service.Path, timeOut)
+	case kHealthCheckTypeGrpc:
+		return el.checkGrpc(address, service.Service, timeOut)
+	default:
+		return el.checkTcp(address, timeOut)
+	}
+}
+
+// the target is healthy when it accepts a tcp connection
+func (el *healthChecker) checkTcp(address string, timeOut time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeOut)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// the target is healthy when GET path returns 2xx or 3xx
+func (el *healthChecker) checkHttp(address, path string, timeOut time.Duration) error {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	client := &http.Client{
+		Timeout: timeOut,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get("http://" + address + path)
+	if err != nil {
+		return err
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		el.onError(err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return errors.New("http status " + resp.Status)
+	}
+
+	return nil
+}
+
+// the target is healthy when grpc.health.v1.Health/Check returns SERVING
+func (el *healthChecker) checkGrpc(address, serviceName string, timeOut time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, address, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: serviceName})
+	if err != nil {
+		return err
+	}
+
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return errors.New("grpc health status " + resp.Status.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/helmutkemper/dns"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	kTxtKeyTarget = "target"
+	kTxtKeyPort   = "port"
+)
+
+// data format saved by the http server plugin, one object for each service instance. This format is compatible with
+// the json of dns.SRV
+type serviceRecord struct {
+	Priority int
+	Weight   int
+	Port     int
+	Target   string
+	Metadata map[string]string `json:",omitempty"`
+	View     string            `json:",omitempty"`
+}
+
+// DNS-SD style TXT record of one service instance. Each metadata is a "key=value" string and the keys target and port
+// identify the SRV record of the instance.
+//
+//   ex.: "target=192.168.0.1." "port=8080" "version=1.2.0" "zone=a"
+func instanceTXT(srv *dns.SRV, metadata map[string]string) *dns.TXT {
+	var keyList = make([]string, 0, len(metadata))
+	var txt = &dns.TXT{
+		TXT: []string{
+			kTxtKeyTarget + "=" + srv.Target,
+			kTxtKeyPort + "=" + strconv.Itoa(srv.Port),
+		},
+	}
+
+	for key := range metadata {
+		if key == kTxtKeyTarget || key == kTxtKeyPort {
+			continue
+		}
+		keyList = append(keyList, key)
+	}
+	sort.Strings(keyList)
+
+	for _, key := range keyList {
+		txt.TXT = append(txt.TXT, key+"="+metadata[key])
+	}
+
+	return txt
+}
+
+// SRV target and port of the instance described by a TXT record made by instanceTXT()
+func txtInstance(txt *dns.TXT) (target string, port int, ok bool) {
+	var err error
+	var foundTarget, foundPort bool
+
+	for _, value := range txt.TXT {
+		switch {
+		case strings.HasPrefix(value, kTxtKeyTarget+"="):
+			target = strings.TrimPrefix(value, kTxtKeyTarget+"=")
+			foundTarget = true
+		case strings.HasPrefix(value, kTxtKeyPort+"="):
+			port, err = strconv.Atoi(strings.TrimPrefix(value, kTxtKeyPort+"="))
+			foundPort = err == nil
+		}
+	}
+
+	return target, port, foundTarget && foundPort
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/helmutkemper/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kProbeDefaultName                = "_canary"
+	kProbeDefaultIntervalMillisecond = 10000
+	kProbeDefaultTimeOutMillisecond  = 1000
+)
+
+var (
+	errProbeNotRun    = errors.New("readiness probe didn't run yet")
+	errProbeNotServed = errors.New("dns listener is not serving")
+)
+
+// readiness probe configuration
+//
+//   name:                reserved canary name, answered by the plugin itself and never stored in the zone.
+//                        default "_canary"
+//   intervalMillisecond: interval between probes. default 10000
+//   timeOutMillisecond:  time out of each probe query. default 1000
+type probeConfig struct {
+	Name                string
+	IntervalMillisecond int
+	TimeOutMillisecond  int
+}
+
+// readinessProbe queries the configured listener, over udp, for a random name below the canary name. The plugin
+// answers the canary names with a TXT record that repeats the first label, so a good answer proves that the query
+// passed by the listener and by the handler of this plugin.
+type readinessProbe struct {
+	sync.RWMutex
+	config  probeConfig
+	fqdn    string
+	address string
+	lastErr error
+	serving error
+	onLog   func(string)
+}
+
+func newReadinessProbe(config probeConfig, origin, addressAndPort string, onLog func(string)) (*readinessProbe, error) {
+	if config.Name == "" {
+		config.Name = kProbeDefaultName
+	}
+
+	if config.IntervalMillisecond == 0 {
+		config.IntervalMillisecond = kProbeDefaultIntervalMillisecond
+	}
+
+	if config.TimeOutMillisecond == 0 {
+		config.TimeOutMillisecond = kProbeDefaultTimeOutMillisecond
+	}
+
+	host, port, err := net.SplitHostPort(addressAndPort)
+	if err != nil {
+		return nil, err
+	}
+
+	// the listener of all interfaces is probed by the loopback interface
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+
+	return &readinessProbe{
+		config:  config,
+		fqdn:    strings.ToLower(strings.TrimSuffix(config.Name, ".")) + "." + origin,
+		address: net.JoinHostPort(host, port),
+		lastErr: errProbeNotRun,
+		serving: errProbeNotServed,
+		onLog:   onLog,
+	}, nil
+}
+
+// true for the canary name and the names below it
+func (el *readinessProbe) IsCanary(name string) bool {
+	name = strings.ToLower(name)
+	return name == el.fqdn || strings.HasSuffix(name, "."+el.fqdn)
+}
+
+// dns.Handler interface. Answers the canary names with the first label of the name
+func (el *readinessProbe) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+	question := r.Questions[0]
+
+	w.Authoritative(true)
+	if question.Type != dns.TypeTXT {
+		return
+	}
+
+	label := strings.SplitN(question.Name, ".", 2)[0]
+	w.Answer(question.Name, 0, &dns.TXT{TXT: []string{label}})
+}
+
+// query the listener now and keep the result, returned by Ready()
+func (el *readinessProbe) Probe() error {
+	var nonce = make([]byte, 8)
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	label := hex.EncodeToString(nonce)
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, "udp", el.address)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(el.config.TimeOutMillisecond)*time.Millisecond)
+	defer cancel()
+
+	txt, err := resolver.LookupTXT(ctx, label+"."+el.fqdn)
+	if err == nil && (len(txt) != 1 || txt[0] != label) {
+		err = errors.New("readiness probe of " + el.address + " got a wrong answer")
+	}
+
+	el.Lock()
+	if err != nil && el.lastErr == nil {
+		el.onLog("readiness probe fail: " + err.Error())
+	}
+	el.lastErr = err
+	el.Unlock()
+
+	return err
+}
+
+// result of the last probe. nil when the plugin is ready
+func (el *readinessProbe) Ready() error {
+	el.RLock()
+	defer el.RUnlock()
+
+	return el.lastErr
+}
+
+// keep the state of the listener. nil while it is serving
+func (el *readinessProbe) Serving(err error) {
+	el.Lock()
+	defer el.Unlock()
+
+	el.serving = err
+}
+
+// state of the listener. nil when the plugin is alive
+func (el *readinessProbe) Live() error {
+	el.RLock()
+	defer el.RUnlock()
+
+	return el.serving
+}
+
+// probe forever
+func (el *readinessProbe) Run() {
+	ticker := time.NewTicker(time.Duration(el.config.IntervalMillisecond) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = el.Probe()
+	}
+}
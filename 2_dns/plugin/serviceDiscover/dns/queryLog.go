@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/helmutkemper/dns"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	kQueryLogDefaultMaxSizeMegaByte = 100
+	kQueryLogDefaultMaxBackups      = 5
+	kQueryLogBufferLength           = 4096
+
+	kTypeANY dns.Type = 255
+)
+
+// query log configuration
+//
+//   file:            text log file path. blank disables the text log
+//   maxSizeMegaByte: size of the file before it is rotated to file.1, file.2...
+//   maxBackups:      number of rotated files kept
+//   dnstap:          dnstap frame stream destination. "unix:/path/to/socket", "tcp:host:port" or a file path. blank
+//                    disables dnstap
+//   identity:        dnstap identity of this server
+type queryLogConfig struct {
+	File            string
+	MaxSizeMegaByte int
+	MaxBackups      int
+	Dnstap          string
+	Identity        string
+}
+
+// everything that is known about a query after it is answered
+type queryLogEntry struct {
+	query    *dns.Query
+	response *dns.Message
+	start    time.Time
+	latency  time.Duration
+}
+
+// MessageWriter that keeps a copy of the response, so it can be logged after the handler returns
+type recordingWriter struct {
+	dns.MessageWriter
+	response dns.Message
+}
+
+func (el *recordingWriter) Authoritative(aa bool) {
+	el.response.Authoritative = aa
+	el.MessageWriter.Authoritative(aa)
+}
+
+func (el *recordingWriter) Status(rcode dns.RCode) {
+	el.response.RCode = rcode
+	el.MessageWriter.Status(rcode)
+}
+
+func (el *recordingWriter) Answer(fqdn string, ttl time.Duration, record dns.Record) {
+	el.response.Answers = append(el.response.Answers, dns.Resource{Name: fqdn, Class: dns.ClassIN, TTL: ttl, Record: record})
+	el.MessageWriter.Answer(fqdn, ttl, record)
+}
+
+func (el *recordingWriter) Authority(fqdn string, ttl time.Duration, record dns.Record) {
+	el.response.Authorities = append(el.response.Authorities, dns.Resource{Name: fqdn, Class: dns.ClassIN, TTL: ttl, Record: record})
+	el.MessageWriter.Authority(fqdn, ttl, record)
+}
+
+func (el *recordingWriter) Additional(fqdn string, ttl time.Duration, record dns.Record) {
+	el.response.Additionals = append(el.response.Additionals, dns.Resource{Name: fqdn, Class: dns.ClassIN, TTL: ttl, Record: record})
+	el.MessageWriter.Additional(fqdn, ttl, record)
+}
+
+// queryLogger writes every query and answer to a rotating text file and/or a dnstap frame stream. Entries are written
+// by a single goroutine; when it can't keep up, entries are dropped instead of delaying the answers.
+type queryLogger struct {
+	entries chan queryLogEntry
+	file    *rotatingFile
+	dnstap  *dnstapWriter
+	onError func(error)
+}
+
+func newQueryLogger(config queryLogConfig, onError func(error)) (*queryLogger, error) {
+	var err error
+	var logger = &queryLogger{
+		entries: make(chan queryLogEntry, kQueryLogBufferLength),
+		onError: onError,
+	}
+
+	if config.File == "" && config.Dnstap == "" {
+		return nil, errors.New("query log needs a file or a dnstap destination")
+	}
+
+	if config.File != "" {
+		if config.MaxSizeMegaByte == 0 {
+			config.MaxSizeMegaByte = kQueryLogDefaultMaxSizeMegaByte
+		}
+
+		if config.MaxBackups == 0 {
+			config.MaxBackups = kQueryLogDefaultMaxBackups
+		}
+
+		logger.file, err = newRotatingFile(config.File, int64(config.MaxSizeMegaByte)*1024*1024, config.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Dnstap != "" {
+		logger.dnstap = newDnstapWriter(config.Dnstap, config.Identity, onError)
+	}
+
+	go logger.run()
+
+	return logger, nil
+}
+
+// serve the query with handler and log the query and its answer
+func (el *queryLogger) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query, handler dns.Handler) {
+	var recorder = &recordingWriter{MessageWriter: w}
+
+	recorder.response.ID = r.ID
+	recorder.response.Response = true
+	recorder.response.OpCode = r.OpCode
+	recorder.response.Questions = r.Questions
+
+	start := time.Now()
+	handler.ServeDNS(ctx, recorder, r)
+
+	select {
+	case el.entries <- queryLogEntry{query: r, response: &recorder.response, start: start, latency: time.Since(start)}:
+	default:
+	}
+}
+
+func (el *queryLogger) run() {
+	for entry := range el.entries {
+		if el.file != nil {
+			_, err := el.file.Write([]byte(el.format(entry)))
+			if err != nil {
+				el.onError(err)
+			}
+		}
+
+		if el.dnstap != nil {
+			el.dnstap.Write(entry)
+		}
+	}
+}
+
+// one line for each question
+//
+//   ex.: 2019-03-12T10:00:00.000Z 172.18.0.5:53122 node.tld. SRV NOERROR answers=2 latency=120µs
+func (el *queryLogger) format(entry queryLogEntry) string {
+	var line string
+	var client = "-"
+
+	if entry.query.RemoteAddr != nil {
+		client = entry.query.RemoteAddr.String()
+	}
+
+	for _, question := range entry.query.Questions {
+		line += fmt.Sprintf(
+			"%v %v %v %v %v answers=%v latency=%v\n",
+			entry.start.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+			client,
+			question.Name,
+			typeName(question.Type),
+			rcodeName(entry.response.RCode),
+			len(entry.response.Answers),
+			entry.latency,
+		)
+	}
+
+	return line
+}
+
+func typeName(recordType dns.Type) string {
+	switch recordType {
+	case dns.TypeA:
+		return "A"
+	case dns.TypeNS:
+		return "NS"
+	case dns.TypeCNAME:
+		return "CNAME"
+	case dns.TypeSOA:
+		return "SOA"
+	case dns.TypePTR:
+		return "PTR"
+	case dns.TypeMX:
+		return "MX"
+	case dns.TypeTXT:
+		return "TXT"
+	case dns.TypeAAAA:
+		return "AAAA"
+	case dns.TypeSRV:
+		return "SRV"
+	case kTypeRRSIG:
+		return "RRSIG"
+	case kTypeNSEC:
+		return "NSEC"
+	case kTypeDNSKEY:
+		return "DNSKEY"
+	case kTypeNSEC3:
+		return "NSEC3"
+	case kTypeNSEC3PARAM:
+		return "NSEC3PARAM"
+	case kTypeIXFR:
+		return "IXFR"
+	case kTypeAXFR:
+		return "AXFR"
+	case kTypeANY:
+		return "ANY"
+	}
+
+	return "TYPE" + strconv.Itoa(int(recordType))
+}
+
+func rcodeName(rcode dns.RCode) string {
+	switch rcode {
+	case dns.NoError:
+		return "NOERROR"
+	case dns.FormErr:
+		return "FORMERR"
+	case dns.ServFail:
+		return "SERVFAIL"
+	case dns.NXDomain:
+		return "NXDOMAIN"
+	case dns.NotImp:
+		return "NOTIMP"
+	case dns.Refused:
+		return "REFUSED"
+	}
+
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+// ip and port of a query source
+func remoteAddress(addr net.Addr) (net.IP, int) {
+	switch converted := addr.(type) {
+	case *net.UDPAddr:
+		return converted.IP, converted.Port
+	case *net.TCPAddr:
+		return converted.IP, converted.Port
+	case *clientSubnetAddr:
+		return remoteAddress(converted.Addr)
+	case *ednsAddr:
+		return remoteAddress(converted.Addr)
+	}
+
+	return nil, 0
+}
+
+// file renamed to path.1, path.2, ... when it reaches maxSize
+type rotatingFile struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	size       int64
+	file       *os.File
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	var rotating = &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	return rotating, rotating.open()
+}
+
+func (el *rotatingFile) open() error {
+	var err error
+	var info os.FileInfo
+
+	el.file, err = os.OpenFile(el.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err = el.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	el.size = info.Size()
+	return nil
+}
+
+func (el *rotatingFile) rotate() error {
+	var err error
+
+	err = el.file.Close()
+	if err != nil {
+		return err
+	}
+
+	for k := el.maxBackups - 1; k > 0; k-- {
+		err = os.Rename(el.path+"."+strconv.Itoa(k), el.path+"."+strconv.Itoa(k+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err = os.Rename(el.path, el.path+".1")
+	if err != nil {
+		return err
+	}
+
+	return el.open()
+}
+
+func (el *rotatingFile) Write(p []byte) (int, error) {
+	el.Lock()
+	defer el.Unlock()
+
+	if el.size+int64(len(p)) > el.maxSize && el.size != 0 {
+		err := el.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := el.file.Write(p)
+	el.size += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	kRateLimitDefaultIPv4PrefixLength = 24
+	kRateLimitDefaultIPv6PrefixLength = 56
+	kRateLimitCleanUpInterval         = time.Minute
+
+	kDnsHeaderLength  = 12
+	kDnsRCodeNXDomain = 3
+)
+
+// rate limit configuration
+//
+//   queriesPerSecond:   udp queries accepted from one source ip. 0 disables the query rate limit
+//   queriesBurst:       queries accepted above queriesPerSecond in a burst
+//   responsesPerSecond: identical responses sent to one client network (RRL). 0 disables the response rate limit
+//   responsesBurst:     responses sent above responsesPerSecond in a burst
+//   slip:               one in each slip limited responses is sent truncated, so a real client can retry over tcp.
+//                       0 drops all limited responses
+//   ipv4PrefixLength:   size of the client network used by RRL. default 24
+//   ipv6PrefixLength:   size of the client network used by RRL. default 56
+//   exempt:             networks never limited. ex.: ["127.0.0.0/8", "172.18.0.0/16"]
+type rateLimitConfig struct {
+	QueriesPerSecond   float64
+	QueriesBurst       float64
+	ResponsesPerSecond float64
+	ResponsesBurst     float64
+	Slip               int
+	IPv4PrefixLength   int
+	IPv6PrefixLength   int
+	Exempt             []string
+}
+
+// rate limit counters exposed to the host
+type rateLimitCounters struct {
+	queries           uint64
+	queriesDropped    uint64
+	responsesDropped  uint64
+	responsesSlipped  uint64
+	responsesAccepted uint64
+}
+
+// token bucket of one key
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type tokenBucketList struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newTokenBucketList(rate, burst float64) *tokenBucketList {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucketList{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// true if one more event is allowed for the key
+func (el *tokenBucketList) Allow(key string, now time.Time) bool {
+	el.Lock()
+	defer el.Unlock()
+
+	bucket, ok := el.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: el.burst, lastSeen: now}
+		el.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.lastSeen).Seconds() * el.rate
+	if bucket.tokens > el.burst {
+		bucket.tokens = el.burst
+	}
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+// remove buckets not used since before
+func (el *tokenBucketList) CleanUp(before time.Time) {
+	el.Lock()
+	defer el.Unlock()
+
+	for key, bucket := range el.buckets {
+		if bucket.lastSeen.Before(before) {
+			delete(el.buckets, key)
+		}
+	}
+}
+
+// rateLimiter protects the udp listener. It drops queries of sources above queriesPerSecond and applies response rate
+// limiting (RRL), dropping or truncating identical responses sent too often to the same client network.
+// Tcp is not limited, because a tcp client can't spoof its address.
+type rateLimiter struct {
+	config    rateLimitConfig
+	queries   *tokenBucketList
+	responses *tokenBucketList
+	exempt    []*net.IPNet
+	counters  rateLimitCounters
+	slip      uint64
+}
+
+func newRateLimiter(config rateLimitConfig) (*rateLimiter, error) {
+	var limiter = &rateLimiter{}
+
+	if config.QueriesPerSecond < 0 || config.ResponsesPerSecond < 0 || config.Slip < 0 {
+		return nil, errors.New("rate limit values must be positive")
+	}
+
+	if config.IPv4PrefixLength == 0 {
+		config.IPv4PrefixLength = kRateLimitDefaultIPv4PrefixLength
+	}
+
+	if config.IPv6PrefixLength == 0 {
+		config.IPv6PrefixLength = kRateLimitDefaultIPv6PrefixLength
+	}
+
+	for _, cidr := range config.Exempt {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		limiter.exempt = append(limiter.exempt, network)
+	}
+
+	if config.QueriesPerSecond != 0 {
+		limiter.queries = newTokenBucketList(config.QueriesPerSecond, config.QueriesBurst)
+	}
+
+	if config.ResponsesPerSecond != 0 {
+		limiter.responses = newTokenBucketList(config.ResponsesPerSecond, config.ResponsesBurst)
+	}
+
+	limiter.config = config
+
+	go limiter.cleanUp()
+
+	return limiter, nil
+}
+
+func (el *rateLimiter) cleanUp() {
+	ticker := time.NewTicker(kRateLimitCleanUpInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if el.queries != nil {
+			el.queries.CleanUp(now.Add(-kRateLimitCleanUpInterval))
+		}
+
+		if el.responses != nil {
+			el.responses.CleanUp(now.Add(-kRateLimitCleanUpInterval))
+		}
+	}
+}
+
+func (el *rateLimiter) isExempt(ip net.IP) bool {
+	for _, network := range el.exempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// client network used as RRL key
+func (el *rateLimiter) network(ip net.IP) string {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(el.config.IPv4PrefixLength, 32)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(el.config.IPv6PrefixLength, 128)).String()
+}
+
+// true if a query from the source ip can be served
+func (el *rateLimiter) AllowQuery(ip net.IP) bool {
+	atomic.AddUint64(&el.counters.queries, 1)
+
+	if el.queries == nil || ip == nil || el.isExempt(ip) {
+		return true
+	}
+
+	if el.queries.Allow(ip.String(), time.Now()) {
+		return true
+	}
+
+	atomic.AddUint64(&el.counters.queriesDropped, 1)
+	return false
+}
+
+// decides what to do with a response: send it, send it truncated or drop it.
+// Responses are identical when they go to the same client network with the same query name and rcode; all NXDOMAIN
+// responses of a network share the same key, so random names can't be used to escape the limit.
+func (el *rateLimiter) Response(ip net.IP, packet []byte) (send bool, truncate bool) {
+	if el.responses == nil || ip == nil || el.isExempt(ip) {
+		return true, false
+	}
+
+	name, ok := packetQuestionName(packet)
+	if !ok {
+		return true, false
+	}
+
+	rcode := packet[3] & 0x0f
+	if rcode == kDnsRCodeNXDomain {
+		name = "*"
+	}
+
+	key := el.network(ip) + "/" + strings.ToLower(name) + "/" + strconv.Itoa(int(rcode))
+	if el.responses.Allow(key, time.Now()) {
+		atomic.AddUint64(&el.counters.responsesAccepted, 1)
+		return true, false
+	}
+
+	if el.config.Slip != 0 && atomic.AddUint64(&el.slip, 1)%uint64(el.config.Slip) == 0 {
+		atomic.AddUint64(&el.counters.responsesSlipped, 1)
+		return true, true
+	}
+
+	atomic.AddUint64(&el.counters.responsesDropped, 1)
+	return false, false
+}
+
+func (el *rateLimiter) Counters() map[string]uint64 {
+	return map[string]uint64{
+		"rateLimit.queries":           atomic.LoadUint64(&el.counters.queries),
+		"rateLimit.queriesDropped":    atomic.LoadUint64(&el.counters.queriesDropped),
+		"rateLimit.responsesAccepted": atomic.LoadUint64(&el.counters.responsesAccepted),
+		"rateLimit.responsesDropped":  atomic.LoadUint64(&el.counters.responsesDropped),
+		"rateLimit.responsesSlipped":  atomic.LoadUint64(&el.counters.responsesSlipped),
+	}
+}
+
+// udp connection with the rate limiter between the socket and the DNS server
+type rateLimitedPacketConn struct {
+	net.PacketConn
+	limiter *rateLimiter
+}
+
+func (el *rateLimitedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := el.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+
+		ip, _ := remoteAddress(addr)
+		if el.limiter.AllowQuery(ip) {
+			return n, addr, err
+		}
+	}
+}
+
+func (el *rateLimitedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	ip, _ := remoteAddress(addr)
+
+	send, truncate := el.limiter.Response(ip, b)
+	if !send {
+		// the server doesn't need to know that the response was dropped
+		return len(b), nil
+	}
+
+	if truncate {
+		_, err := el.PacketConn.WriteTo(truncatePacket(b), addr)
+		return len(b), err
+	}
+
+	return el.PacketConn.WriteTo(b, addr)
+}
+
+// length of the question section of a packet, starting at the end of the header
+func packetQuestionLength(packet []byte) (int, bool) {
+	var offset = kDnsHeaderLength
+
+	if len(packet) < kDnsHeaderLength || packet[4] != 0 || packet[5] != 1 {
+		return 0, false
+	}
+
+	for {
+		if offset >= len(packet) {
+			return 0, false
+		}
+
+		length := int(packet[offset])
+		if length == 0 {
+			offset++
+			break
+		}
+
+		// compression pointer
+		if length&0xc0 == 0xc0 {
+			offset += 2
+			break
+		}
+
+		offset += length + 1
+	}
+
+	// qtype and qclass
+	offset += 4
+	if offset > len(packet) {
+		return 0, false
+	}
+
+	return offset - kDnsHeaderLength, true
+}
+
+// query name of a packet with one question
+func packetQuestionName(packet []byte) (string, bool) {
+	var labels []string
+
+	questionLength, ok := packetQuestionLength(packet)
+	if !ok {
+		return "", false
+	}
+
+	for offset := kDnsHeaderLength; offset < kDnsHeaderLength+questionLength-4; {
+		length := int(packet[offset])
+		if length == 0 || length&0xc0 == 0xc0 {
+			break
+		}
+
+		labels = append(labels, string(packet[offset+1:offset+1+length]))
+		offset += length + 1
+	}
+
+	return strings.Join(labels, ".") + ".", true
+}
+
+// copy of the packet with the TC flag set and only the header and the question, so the client retries over tcp
+func truncatePacket(packet []byte) []byte {
+	if len(packet) < kDnsHeaderLength {
+		return packet
+	}
+
+	questionLength, ok := packetQuestionLength(packet)
+	if !ok {
+		questionLength = 0
+	}
+
+	truncated := make([]byte, kDnsHeaderLength+questionLength)
+	copy(truncated, packet[:kDnsHeaderLength+questionLength])
+
+	// TC flag
+	truncated[2] |= 0x02
+
+	// question count and answer, authority and additional counts
+	if !ok {
+		truncated[4], truncated[5] = 0, 0
+	}
+	for k := 6; k < kDnsHeaderLength; k++ {
+		truncated[k] = 0
+	}
+
+	return truncated
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"github.com/helmutkemper/dns"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kReverseZoneIPv4 = "in-addr.arpa."
+	kReverseZoneIPv6 = "ip6.arpa."
+)
+
+// reverseZone answers PTR queries with the name of the services registered at an address. The list is rebuilt from
+// the service zone every time a service changes, so it never needs to be registered by hand.
+type reverseZone struct {
+	sync.RWMutex
+	ttl   time.Duration
+	soa   func() *dns.SOA
+	names map[string][]string
+}
+
+func newReverseZone(ttl time.Duration, soa func() *dns.SOA) *reverseZone {
+	return &reverseZone{
+		ttl:   ttl,
+		soa:   soa,
+		names: make(map[string][]string),
+	}
+}
+
+// true if the query name belongs to in-addr.arpa. or ip6.arpa.
+func isReverseName(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, "."+kReverseZoneIPv4) || strings.HasSuffix(name, "."+kReverseZoneIPv6)
+}
+
+// converts an ip into the PTR query name. ex.: 192.168.0.1 to "1.0.168.192.in-addr.arpa."
+func reverseName(ip net.IP) string {
+	var name []string
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		for k := len(ipv4) - 1; k >= 0; k-- {
+			name = append(name, strconv.Itoa(int(ipv4[k])))
+		}
+
+		return strings.Join(name, ".") + "." + kReverseZoneIPv4
+	}
+
+	ipv6 := ip.To16()
+	if ipv6 == nil {
+		return ""
+	}
+
+	for k := len(ipv6) - 1; k >= 0; k-- {
+		name = append(name, strconv.FormatUint(uint64(ipv6[k]&0x0f), 16), strconv.FormatUint(uint64(ipv6[k]>>4), 16))
+	}
+
+	return strings.Join(name, ".") + "." + kReverseZoneIPv6
+}
+
+// addresses published by a record. SRV targets are only used when they are ip addresses, ex.: "192.168.0.1."
+func recordAddresses(record dns.Record) []net.IP {
+	switch converted := record.(type) {
+	case *dns.A:
+		return []net.IP{converted.A}
+	case *dns.AAAA:
+		return []net.IP{converted.AAAA}
+	case *dns.SRV:
+		ip := net.ParseIP(strings.TrimSuffix(converted.Target, "."))
+		if ip != nil {
+			return []net.IP{ip}
+		}
+	}
+
+	return nil
+}
+
+// rebuild the PTR list from all addresses found in the service zone
+func (el *reverseZone) Build(zone *serviceZone) {
+	var found = make(map[string]map[string]bool)
+
+	for _, serviceName := range zone.Names() {
+		for _, list := range zone.Get(serviceName) {
+			for _, record := range list {
+				for _, ip := range recordAddresses(record) {
+					name := reverseName(ip)
+					if name == "" {
+						continue
+					}
+
+					if found[name] == nil {
+						found[name] = make(map[string]bool)
+					}
+					found[name][zone.Fqdn(serviceName)] = true
+				}
+			}
+		}
+	}
+
+	names := make(map[string][]string)
+	for name, fqdnList := range found {
+		for fqdn := range fqdnList {
+			names[name] = append(names[name], fqdn)
+		}
+		sort.Strings(names[name])
+	}
+
+	el.Lock()
+	el.names = names
+	el.Unlock()
+}
+
+// all PTR records of a reverse zone, sorted by name, used by zone transfers
+func (el *reverseZone) Transfer(origin string) []dns.Resource {
+	var ret []dns.Resource
+	var nameList []string
+
+	el.RLock()
+	defer el.RUnlock()
+
+	for name := range el.names {
+		if strings.HasSuffix(name, "."+origin) {
+			nameList = append(nameList, name)
+		}
+	}
+	sort.Strings(nameList)
+
+	for _, name := range nameList {
+		for _, fqdn := range el.names[name] {
+			ret = append(ret, dns.Resource{Name: name, Class: dns.ClassIN, TTL: el.ttl, Record: &dns.PTR{PTR: fqdn}})
+		}
+	}
+
+	return ret
+}
+
+// true if the name has PTR records, is an empty non-terminal above them or is the origin of a reverse zone. Names that
+// exist are answered with NODATA for the other types, never NXDOMAIN, so resolvers don't cache the name as missing.
+// Must be called with the lock held
+func (el *reverseZone) exists(name string) bool {
+	if name == kReverseZoneIPv4 || name == kReverseZoneIPv6 {
+		return true
+	}
+
+	if _, ok := el.names[name]; ok {
+		return true
+	}
+
+	for reverseName := range el.names {
+		if strings.HasSuffix(reverseName, "."+name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dns.Handler interface
+func (el *reverseZone) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+	var found bool
+	var exists bool
+
+	w.Authoritative(true)
+
+	el.RLock()
+	defer el.RUnlock()
+
+	for _, question := range r.Questions {
+		name := strings.ToLower(question.Name)
+		exists = exists || el.exists(name)
+
+		if question.Type != dns.TypePTR && question.Type != kTypeANY {
+			continue
+		}
+
+		for _, fqdn := range el.names[name] {
+			w.Answer(question.Name, el.ttl, &dns.PTR{PTR: fqdn})
+			found = true
+		}
+	}
+
+	if !found {
+		if !exists {
+			w.Status(dns.NXDomain)
+		}
+
+		if len(r.Questions) != 0 {
+			soa := el.soa()
+			w.Authority(el.origin(r.Questions[0].Name), negativeTTL(el.ttl, soa), soa)
+		}
+	}
+}
+
+func (el *reverseZone) origin(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), "."+kReverseZoneIPv6) {
+		return kReverseZoneIPv6
+	}
+
+	return kReverseZoneIPv4
+}
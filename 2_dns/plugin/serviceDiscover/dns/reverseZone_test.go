@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"github.com/helmutkemper/dns"
+	"net"
+	"testing"
+	"time"
+)
+
+func testReverseZone() *reverseZone {
+	zone := testZone()
+
+	reverse := newReverseZone(time.Hour, zone.SOA)
+	reverse.Build(zone)
+
+	return reverse
+}
+
+func testReverseServe(reverse *reverseZone, name string, recordType dns.Type) dns.Message {
+	w := &testWriter{}
+	reverse.ServeDNS(context.Background(), w, &dns.Query{Message: &dns.Message{Questions: []dns.Question{{Name: name, Type: recordType, Class: dns.ClassIN}}}})
+
+	return w.response
+}
+
+// negative answers of the reverse zone have the SOA of the reverse origin in the authority section
+func testReverseNegative(t *testing.T, response dns.Message, rcode dns.RCode, origin string) {
+	if response.RCode != rcode {
+		t.Errorf("rcode error, want=%v, get=%v", rcode, response.RCode)
+	}
+
+	if len(response.Answers) != 0 {
+		t.Errorf("answer section error, want=0 records, get=%v", len(response.Answers))
+	}
+
+	if len(response.Authorities) != 1 {
+		t.Fatalf("authority section error, want=1 record, get=%v", len(response.Authorities))
+	}
+
+	authority := response.Authorities[0]
+	if _, ok := authority.Record.(*dns.SOA); !ok || authority.Name != origin {
+		t.Errorf("authority section error, want=SOA of %v, get=%v of %v", origin, typeName(authority.Record.Type()), authority.Name)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	if name := reverseName(net.ParseIP("192.168.0.1")); name != "1.0.168.192.in-addr.arpa." {
+		t.Errorf("ipv4 reverse name error, get=%v", name)
+	}
+
+	if name := reverseName(net.ParseIP("fd00::1")); name != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa." {
+		t.Errorf("ipv6 reverse name error, get=%v", name)
+	}
+}
+
+func TestReverseZoneAnswer(t *testing.T) {
+	response := testReverseServe(testReverseZone(), "1.0.0.10.in-addr.arpa.", dns.TypePTR)
+
+	if response.RCode != dns.NoError || len(response.Answers) != 1 || len(response.Authorities) != 0 {
+		t.Fatalf("answer error, get=%+v", response)
+	}
+
+	if ptr, ok := response.Answers[0].Record.(*dns.PTR); !ok || ptr.PTR != "node1.tld." {
+		t.Errorf("answer error, want=PTR node1.tld., get=%+v", response.Answers[0].Record)
+	}
+}
+
+func TestReverseZoneAnswerAny(t *testing.T) {
+	response := testReverseServe(testReverseZone(), "1.0.0.10.in-addr.arpa.", kTypeANY)
+
+	if response.RCode != dns.NoError || len(response.Answers) != 1 {
+		t.Errorf("answer error, get=%+v", response)
+	}
+}
+
+func TestReverseZoneNxDomain(t *testing.T) {
+	testReverseNegative(t, testReverseServe(testReverseZone(), "2.0.0.10.in-addr.arpa.", dns.TypePTR), dns.NXDomain, "in-addr.arpa.")
+}
+
+func TestReverseZoneNxDomainIPv6(t *testing.T) {
+	testReverseNegative(t, testReverseServe(testReverseZone(), "1.0.0.0.d.f.ip6.arpa.", dns.TypePTR), dns.NXDomain, "ip6.arpa.")
+}
+
+// a name with PTR records queried for other types exists, so it isn't cached as missing by the resolvers
+func TestReverseZoneNoDataOtherType(t *testing.T) {
+	reverse := testReverseZone()
+
+	for _, recordType := range []dns.Type{dns.TypeA, dns.TypeTXT, dns.TypeAAAA} {
+		testReverseNegative(t, testReverseServe(reverse, "1.0.0.10.in-addr.arpa.", recordType), dns.NoError, "in-addr.arpa.")
+	}
+}
+
+func TestReverseZoneNoDataEmptyNonTerminal(t *testing.T) {
+	testReverseNegative(t, testReverseServe(testReverseZone(), "0.0.10.in-addr.arpa.", dns.TypePTR), dns.NoError, "in-addr.arpa.")
+}
+
+func TestReverseZoneNoDataOrigin(t *testing.T) {
+	testReverseNegative(t, testReverseServe(testReverseZone(), "in-addr.arpa.", dns.TypePTR), dns.NoError, "in-addr.arpa.")
+}
+
+// the list is rebuilt when the services change
+func TestReverseZoneBuild(t *testing.T) {
+	zone := testZone()
+	reverse := newReverseZone(time.Hour, zone.SOA)
+	reverse.Build(zone)
+
+	zone.Set(map[string]map[dns.Type][]dns.Record{
+		"node2": {dns.TypeA: {&dns.A{A: []byte{10, 0, 0, 2}}}},
+	})
+	reverse.Build(zone)
+
+	testReverseNegative(t, testReverseServe(reverse, "1.0.0.10.in-addr.arpa.", dns.TypePTR), dns.NXDomain, "in-addr.arpa.")
+
+	response := testReverseServe(reverse, "2.0.0.10.in-addr.arpa.", dns.TypePTR)
+	if len(response.Answers) != 1 {
+		t.Errorf("answer error, want=1 PTR record, get=%+v", response)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/helmutkemper/dns"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const kEdnsOptionClientSubnet = 8
+
+// split horizon configuration
+//
+//   views:            client networks of each view. The first view with a network of the client is used.
+//                     ex.: [{"name": "internal", "networks": ["10.0.0.0/8"]},
+//                           {"name": "external", "networks": ["0.0.0.0/0", "::/0"]}]
+//   ednsClientSubnet: use the EDNS Client Subnet option (RFC 7871), sent by resolvers on behalf of their clients,
+//                     instead of the source address of udp queries
+type splitHorizonConfig struct {
+	Views            []splitHorizonViewConfig
+	EdnsClientSubnet bool
+}
+
+type splitHorizonViewConfig struct {
+	Name     string
+	Networks []string
+}
+
+type splitHorizonView struct {
+	name     string
+	networks []*net.IPNet
+}
+
+// splitHorizon hides the instances registered for one view from the clients of the other views. Instances registered
+// without a view are served to everyone.
+type splitHorizon struct {
+	sync.RWMutex
+	views        []splitHorizonView
+	clientSubnet bool
+	instances    map[string]map[string]string
+}
+
+func newSplitHorizon(config splitHorizonConfig) (*splitHorizon, error) {
+	var horizon = &splitHorizon{
+		clientSubnet: config.EdnsClientSubnet,
+		instances:    make(map[string]map[string]string),
+	}
+
+	if len(config.Views) == 0 {
+		return nil, errors.New("split horizon needs at least one view")
+	}
+
+	for _, viewConfig := range config.Views {
+		var view = splitHorizonView{name: viewConfig.Name}
+
+		if view.name == "" {
+			return nil, errors.New("split horizon view name must be a non empty string")
+		}
+
+		for _, cidr := range viewConfig.Networks {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			view.networks = append(view.networks, network)
+		}
+
+		horizon.views = append(horizon.views, view)
+	}
+
+	return horizon, nil
+}
+
+func (el *splitHorizon) key(srv *dns.SRV) string {
+	return net.JoinHostPort(srv.Target, strconv.Itoa(srv.Port))
+}
+
+// keep the view of each instance of the service. The names are kept in lower case, as served by the zone
+func (el *splitHorizon) SetService(serviceName string, records []serviceRecord) {
+	var instances = make(map[string]string)
+
+	for _, record := range records {
+		if record.View != "" {
+			instances[el.key(&dns.SRV{Target: record.Target, Port: record.Port})] = record.View
+		}
+	}
+
+	el.Lock()
+	defer el.Unlock()
+
+	el.instances[strings.ToLower(serviceName)] = instances
+}
+
+func (el *splitHorizon) RemoveService(serviceName string) {
+	el.Lock()
+	defer el.Unlock()
+
+	delete(el.instances, strings.ToLower(serviceName))
+}
+
+// view of the client. Blank when the client isn't in any view
+func (el *splitHorizon) View(addr net.Addr) string {
+	var ip net.IP
+
+	if subnetAddr, ok := addr.(*clientSubnetAddr); ok && el.clientSubnet {
+		ip = subnetAddr.subnet.IP
+	} else {
+		ip, _ = remoteAddress(addr)
+	}
+
+	if ip == nil {
+		return ""
+	}
+
+	for _, view := range el.views {
+		for _, network := range view.networks {
+			if network.Contains(ip) {
+				return view.name
+			}
+		}
+	}
+
+	return ""
+}
+
+// recordFilter of the service zone. Instances of a view are only served to the clients of the view
+func (el *splitHorizon) IsVisible(query *dns.Query, serviceName string, record dns.Record) bool {
+	var srv *dns.SRV
+
+	switch converted := record.(type) {
+	case *dns.SRV:
+		srv = converted
+	case *dns.TXT:
+		target, port, ok := txtInstance(converted)
+		if !ok {
+			return true
+		}
+		srv = &dns.SRV{Target: target, Port: port}
+	default:
+		return true
+	}
+
+	el.RLock()
+	view := el.instances[strings.ToLower(serviceName)][el.key(srv)]
+	el.RUnlock()
+
+	if view == "" {
+		return true
+	}
+
+	return query != nil && view == el.View(query.RemoteAddr)
+}
+
+// address of a udp query with the EDNS Client Subnet option
+type clientSubnetAddr struct {
+	net.Addr
+	subnet *net.IPNet
+	option []byte
+}
+
+// udp connection that reads the EDNS Client Subnet option of the queries and sends it back in the responses
+type clientSubnetPacketConn struct {
+	net.PacketConn
+	udpPayloadSize uint16
+}
+
+func (el *clientSubnetPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := el.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+
+	subnet, option, ok := packetClientSubnet(b[:n])
+	if !ok {
+		return n, addr, err
+	}
+
+	return n, &clientSubnetAddr{Addr: addr, subnet: subnet, option: option}, nil
+}
+
+func (el *clientSubnetPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	subnetAddr, ok := addr.(*clientSubnetAddr)
+	if !ok {
+		return el.PacketConn.WriteTo(b, addr)
+	}
+
+	_, err := el.PacketConn.WriteTo(appendClientSubnet(b, subnetAddr.option, el.udpPayloadSize), subnetAddr.Addr)
+	return len(b), err
+}
+
+// all resource records of a packet
+func packetResources(packet []byte) ([]wireResource, bool) {
+	var err error
+	var offset = kDnsHeaderLength
+
+	if len(packet) < kDnsHeaderLength {
+		return nil, false
+	}
+
+	for k := 0; k < int(binary.BigEndian.Uint16(packet[4:])); k++ {
+		_, _, _, offset, err = wireReadQuestion(packet, offset)
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	count := int(binary.BigEndian.Uint16(packet[6:])) + int(binary.BigEndian.Uint16(packet[8:])) + int(binary.BigEndian.Uint16(packet[10:]))
+	resources, _, err := wireReadResourceList(packet, offset, count)
+
+	return resources, err == nil
+}
+
+// subnet and option data of the EDNS Client Subnet option of a query
+func packetClientSubnet(packet []byte) (*net.IPNet, []byte, bool) {
+	resources, ok := packetResources(packet)
+	if !ok {
+		return nil, nil, false
+	}
+
+	for _, resource := range resources {
+		if resource.rrType != dns.TypeOPT {
+			continue
+		}
+
+		for options := resource.rdata; len(options) >= 4; {
+			code := binary.BigEndian.Uint16(options)
+			length := int(binary.BigEndian.Uint16(options[2:]))
+			if len(options) < 4+length {
+				return nil, nil, false
+			}
+
+			data := options[4 : 4+length]
+			options = options[4+length:]
+
+			if code != kEdnsOptionClientSubnet || len(data) < 4 {
+				continue
+			}
+
+			var ip net.IP
+			switch binary.BigEndian.Uint16(data) {
+			case 1:
+				ip = make(net.IP, net.IPv4len)
+			case 2:
+				ip = make(net.IP, net.IPv6len)
+			default:
+				return nil, nil, false
+			}
+
+			sourcePrefix := int(data[2])
+			if sourcePrefix > len(ip)*8 || len(data)-4 > len(ip) {
+				return nil, nil, false
+			}
+			copy(ip, data[4:])
+
+			mask := net.CIDRMask(sourcePrefix, len(ip)*8)
+			return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, append([]byte{}, data...), true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// response with the EDNS Client Subnet option of the query. The scope is the source prefix of the query, RFC 7871
+// section 7.2.1
+func appendClientSubnet(response []byte, option []byte, udpPayloadSize uint16) []byte {
+	var opt *wireResource
+
+	resources, ok := packetResources(response)
+	if !ok {
+		return response
+	}
+
+	for k := range resources {
+		if resources[k].rrType == dns.TypeOPT {
+			opt = &resources[k]
+		}
+	}
+
+	option = append([]byte{}, option...)
+	option[3] = option[2]
+
+	data := wireAppendUint16(nil, kEdnsOptionClientSubnet)
+	data = wireAppendUint16(data, uint16(len(option)))
+	data = append(data, option...)
+
+	if opt == nil {
+		return appendOpt(response, udpPayloadSize, data)
+	}
+
+	// the option is only added when the OPT record is the last record of the response
+	if opt.rdataOffset+len(opt.rdata) != len(response) {
+		return response
+	}
+
+	response = append(append([]byte{}, response...), data...)
+	binary.BigEndian.PutUint16(response[opt.rdataOffset-2:], uint16(len(opt.rdata)+len(data)))
+
+	return response
+}
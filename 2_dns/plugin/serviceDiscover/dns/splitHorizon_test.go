@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/helmutkemper/dns"
+	"net"
+	"testing"
+)
+
+func testSplitHorizon(t *testing.T) *splitHorizon {
+	horizon, err := newSplitHorizon(splitHorizonConfig{Views: []splitHorizonViewConfig{
+		{Name: "internal", Networks: []string{"10.0.0.0/8"}},
+		{Name: "external", Networks: []string{"0.0.0.0/0"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return horizon
+}
+
+func testViewQuery(ip string) *dns.Query {
+	return &dns.Query{RemoteAddr: &net.UDPAddr{IP: net.ParseIP(ip), Port: 5353}}
+}
+
+func TestSplitHorizonIsVisible(t *testing.T) {
+	horizon := testSplitHorizon(t)
+	horizon.SetService("node", []serviceRecord{
+		{Target: "node1.tld.", Port: 8080, View: "internal"},
+		{Target: "node2.tld.", Port: 8080},
+	})
+
+	internal := &dns.SRV{Target: "node1.tld.", Port: 8080}
+	everyone := &dns.SRV{Target: "node2.tld.", Port: 8080}
+
+	if !horizon.IsVisible(testViewQuery("10.0.0.5"), "node", internal) {
+		t.Errorf("instance of the view must be served to the view")
+	}
+
+	if horizon.IsVisible(testViewQuery("192.168.0.5"), "node", internal) {
+		t.Errorf("instance of the view must not be served to other views")
+	}
+
+	if !horizon.IsVisible(testViewQuery("192.168.0.5"), "node", everyone) {
+		t.Errorf("instance without view must be served to everyone")
+	}
+}
+
+// the zone serves the names in lower case, so services saved with upper case letters keep their views
+func TestSplitHorizonMixedCaseName(t *testing.T) {
+	horizon := testSplitHorizon(t)
+	horizon.SetService("Node", []serviceRecord{{Target: "node1.tld.", Port: 8080, View: "internal"}})
+
+	srv := &dns.SRV{Target: "node1.tld.", Port: 8080}
+
+	if horizon.IsVisible(testViewQuery("192.168.0.5"), "node", srv) {
+		t.Errorf("instance of the view must not be served to other views")
+	}
+
+	if !horizon.IsVisible(testViewQuery("10.0.0.5"), "node", srv) {
+		t.Errorf("instance of the view must be served to the view")
+	}
+
+	horizon.RemoveService("NODE")
+	if len(horizon.instances) != 0 {
+		t.Errorf("service must be removed, get=%v", horizon.instances)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/helmutkemper/dns"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TSIG - RFC 8945
+const (
+	kTypeTSIG dns.Type = 250
+
+	kDnsClassANY  = 255
+	kDnsClassNONE = 254
+
+	kTsigAlgorithmSha1   = "hmac-sha1."
+	kTsigAlgorithmSha256 = "hmac-sha256."
+	kTsigAlgorithmSha512 = "hmac-sha512."
+
+	kTsigDefaultFudgeSecond = 300
+)
+
+var (
+	errTsigBadKey  = errors.New("tsig key not found")
+	errTsigBadSig  = errors.New("tsig signature doesn't match")
+	errTsigBadTime = errors.New("tsig time out of the fudge window")
+)
+
+// TSIG key configuration
+//
+//   algorithm: "hmac-sha1", "hmac-sha256" or "hmac-sha512"
+//   secret:    base64 secret, as made by tsig-keygen
+type tsigKeyConfig struct {
+	Algorithm string
+	Secret    string
+}
+
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+}
+
+func newTsigKey(name string, config tsigKeyConfig) (tsigKey, error) {
+	var err error
+	var key = tsigKey{
+		name:      strings.ToLower(strings.TrimSuffix(name, ".") + "."),
+		algorithm: strings.ToLower(strings.TrimSuffix(config.Algorithm, ".") + "."),
+	}
+
+	if key.hash() == nil {
+		return key, errors.New("tsig key " + name + " algorithm must be hmac-sha1, hmac-sha256 or hmac-sha512")
+	}
+
+	key.secret, err = base64.StdEncoding.DecodeString(config.Secret)
+	if err != nil {
+		return key, errors.New("tsig key " + name + " secret must be base64: " + err.Error())
+	}
+
+	return key, nil
+}
+
+func (el tsigKey) hash() func() hash.Hash {
+	switch el.algorithm {
+	case kTsigAlgorithmSha1:
+		return sha1.New
+	case kTsigAlgorithmSha256:
+		return sha256.New
+	case kTsigAlgorithmSha512:
+		return sha512.New
+	}
+
+	return nil
+}
+
+// TSIG record found at the end of a message
+type tsigRecord struct {
+	keyName    string
+	algorithm  string
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	error      uint16
+	otherData  []byte
+	offset     int
+}
+
+func parseTsig(message []byte, resource wireResource) (tsigRecord, error) {
+	var err error
+	var offset int
+	var tsig = tsigRecord{keyName: resource.name, offset: resource.offset}
+	var end = resource.rdataOffset + len(resource.rdata)
+
+	tsig.algorithm, offset, err = wireReadName(message, resource.rdataOffset)
+	if err != nil {
+		return tsig, err
+	}
+
+	if offset+10 > end {
+		return tsig, errWireFormat
+	}
+
+	tsig.timeSigned = uint64(binary.BigEndian.Uint16(message[offset:]))<<32 | uint64(binary.BigEndian.Uint32(message[offset+2:]))
+	tsig.fudge = binary.BigEndian.Uint16(message[offset+6:])
+	macLength := int(binary.BigEndian.Uint16(message[offset+8:]))
+	offset += 10
+
+	if offset+macLength+6 > end {
+		return tsig, errWireFormat
+	}
+
+	tsig.mac = message[offset : offset+macLength]
+	offset += macLength
+
+	tsig.originalID = binary.BigEndian.Uint16(message[offset:])
+	tsig.error = binary.BigEndian.Uint16(message[offset+2:])
+	otherLength := int(binary.BigEndian.Uint16(message[offset+4:]))
+	offset += 6
+
+	if offset+otherLength > end {
+		return tsig, errWireFormat
+	}
+
+	tsig.otherData = message[offset : offset+otherLength]
+
+	return tsig, nil
+}
+
+// TSIG variables added to the message before the MAC is calculated
+func (el tsigRecord) variables() []byte {
+	var b []byte
+
+	b = wireAppendName(b, el.keyName)
+	b = wireAppendUint16(b, kDnsClassANY)
+	b = wireAppendUint32(b, 0)
+	b = wireAppendName(b, el.algorithm)
+	b = wireAppendUint48(b, el.timeSigned)
+	b = wireAppendUint16(b, el.fudge)
+	b = wireAppendUint16(b, el.error)
+	b = wireAppendUint16(b, uint16(len(el.otherData)))
+
+	return append(b, el.otherData...)
+}
+
+// message as it was before the TSIG record was added: without the record, one additional record less and the original
+// id
+func (el tsigRecord) unsignedMessage(message []byte) []byte {
+	var unsigned = append([]byte{}, message[:el.offset]...)
+
+	binary.BigEndian.PutUint16(unsigned, el.originalID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+
+	return unsigned
+}
+
+func tsigMAC(key tsigKey, requestMAC, message, variables []byte) []byte {
+	mac := hmac.New(key.hash(), key.secret)
+
+	if requestMAC != nil {
+		_, _ = mac.Write(wireAppendUint16(nil, uint16(len(requestMAC))))
+		_, _ = mac.Write(requestMAC)
+	}
+
+	_, _ = mac.Write(message)
+	_, _ = mac.Write(variables)
+
+	return mac.Sum(nil)
+}
+
+// verify the TSIG record of a request signed by one of the keys
+func verifyTsig(message []byte, tsig tsigRecord, keys map[string]tsigKey, fudge time.Duration, now time.Time) (tsigKey, error) {
+	key, ok := keys[tsig.keyName]
+	if !ok || key.algorithm != tsig.algorithm {
+		return key, errTsigBadKey
+	}
+
+	expected := tsigMAC(key, nil, tsig.unsignedMessage(message), tsig.variables())
+	if !hmac.Equal(expected, tsig.mac) {
+		return key, errTsigBadSig
+	}
+
+	signed := time.Unix(int64(tsig.timeSigned), 0)
+	window := time.Duration(tsig.fudge) * time.Second
+	if fudge < window {
+		window = fudge
+	}
+
+	if now.Sub(signed) > window || signed.Sub(now) > window {
+		return key, errTsigBadTime
+	}
+
+	return key, nil
+}
+
+// append the TSIG record of a response to the request signed with requestMAC
+func signTsig(response []byte, key tsigKey, requestMAC []byte, now time.Time) []byte {
+	var rdata []byte
+	var tsig = tsigRecord{
+		keyName:    key.name,
+		algorithm:  key.algorithm,
+		timeSigned: uint64(now.Unix()),
+		fudge:      kTsigDefaultFudgeSecond,
+		originalID: binary.BigEndian.Uint16(response),
+	}
+
+	mac := tsigMAC(key, requestMAC, response, tsig.variables())
+
+	rdata = wireAppendName(rdata, tsig.algorithm)
+	rdata = wireAppendUint48(rdata, tsig.timeSigned)
+	rdata = wireAppendUint16(rdata, tsig.fudge)
+	rdata = wireAppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = wireAppendUint16(rdata, tsig.originalID)
+	rdata = wireAppendUint16(rdata, tsig.error)
+	rdata = wireAppendUint16(rdata, 0)
+
+	signed := append([]byte{}, response...)
+	signed = wireAppendName(signed, tsig.keyName)
+	signed = wireAppendUint16(signed, uint16(kTypeTSIG))
+	signed = wireAppendUint16(signed, kDnsClassANY)
+	signed = wireAppendUint32(signed, 0)
+	signed = wireAppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+
+	// one more additional record
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+
+	return signed
+}
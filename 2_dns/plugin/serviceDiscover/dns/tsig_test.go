@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/helmutkemper/dns"
+	"testing"
+	"time"
+)
+
+// resource record of the messages made by the tests
+type testResource struct {
+	name   string
+	rrType dns.Type
+	class  uint16
+	ttl    uint32
+	rdata  []byte
+}
+
+func testAppendResource(b []byte, resource testResource) []byte {
+	b = wireAppendName(b, resource.name)
+	b = wireAppendUint16(b, uint16(resource.rrType))
+	b = wireAppendUint16(b, resource.class)
+	b = wireAppendUint32(b, resource.ttl)
+	b = wireAppendUint16(b, uint16(len(resource.rdata)))
+
+	return append(b, resource.rdata...)
+}
+
+// UPDATE message of the zone, RFC 2136 section 2
+func testUpdateMessage(zone string, prerequisites, updates []testResource) []byte {
+	var message = make([]byte, kDnsHeaderLength)
+
+	binary.BigEndian.PutUint16(message, 0x1234)
+	message[2] = kDnsOpCodeUpdate << 3
+	binary.BigEndian.PutUint16(message[4:], 1)
+	binary.BigEndian.PutUint16(message[6:], uint16(len(prerequisites)))
+	binary.BigEndian.PutUint16(message[8:], uint16(len(updates)))
+
+	message = wireAppendName(message, zone)
+	message = wireAppendUint16(message, uint16(dns.TypeSOA))
+	message = wireAppendUint16(message, uint16(dns.ClassIN))
+
+	for _, resource := range append(prerequisites, updates...) {
+		message = testAppendResource(message, resource)
+	}
+
+	return message
+}
+
+func testTsigKey(t *testing.T) tsigKey {
+	key, err := newTsigKey("update.key", tsigKeyConfig{Algorithm: "hmac-sha256", Secret: "c2VjcmV0IG9mIHRoZSB1cGRhdGUga2V5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// TSIG record of a signed message, the last additional record
+func testReadTsig(t *testing.T, message []byte) tsigRecord {
+	_, _, _, offset, err := wireReadQuestion(message, kDnsHeaderLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := int(binary.BigEndian.Uint16(message[6:])) + int(binary.BigEndian.Uint16(message[8:])) + int(binary.BigEndian.Uint16(message[10:]))
+	resources, _, err := wireReadResourceList(message, offset, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resources) == 0 || resources[len(resources)-1].rrType != kTypeTSIG {
+		t.Fatalf("tsig error, the last record must be TSIG")
+	}
+
+	tsig, err := parseTsig(message, resources[len(resources)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tsig
+}
+
+func TestTsigSignVerify(t *testing.T) {
+	key := testTsigKey(t)
+	keys := map[string]tsigKey{key.name: key}
+	now := time.Now()
+
+	signed := signTsig(testUpdateMessage("tld.", nil, nil), key, nil, now)
+	tsig := testReadTsig(t, signed)
+
+	if tsig.keyName != "update.key." || tsig.algorithm != kTsigAlgorithmSha256 || len(tsig.mac) != 32 {
+		t.Errorf("tsig record error, get=%+v", tsig)
+	}
+
+	if _, err := verifyTsig(signed, tsig, keys, time.Minute, now); err != nil {
+		t.Errorf("verify error: %v", err)
+	}
+}
+
+func TestTsigVerifyBadSig(t *testing.T) {
+	key := testTsigKey(t)
+	signed := signTsig(testUpdateMessage("tld.", nil, nil), key, nil, time.Now())
+
+	// zone name changed after the signature
+	signed[kDnsHeaderLength+1] = 'x'
+
+	_, err := verifyTsig(signed, testReadTsig(t, signed), map[string]tsigKey{key.name: key}, time.Minute, time.Now())
+	if err != errTsigBadSig {
+		t.Errorf("verify error, want=%v, get=%v", errTsigBadSig, err)
+	}
+}
+
+func TestTsigVerifyBadKey(t *testing.T) {
+	key := testTsigKey(t)
+	signed := signTsig(testUpdateMessage("tld.", nil, nil), key, nil, time.Now())
+
+	_, err := verifyTsig(signed, testReadTsig(t, signed), map[string]tsigKey{}, time.Minute, time.Now())
+	if err != errTsigBadKey {
+		t.Errorf("verify error, want=%v, get=%v", errTsigBadKey, err)
+	}
+}
+
+func TestTsigVerifyBadTime(t *testing.T) {
+	key := testTsigKey(t)
+	signed := signTsig(testUpdateMessage("tld.", nil, nil), key, nil, time.Now().Add(-time.Hour))
+
+	_, err := verifyTsig(signed, testReadTsig(t, signed), map[string]tsigKey{key.name: key}, time.Minute, time.Now())
+	if err != errTsigBadTime {
+		t.Errorf("verify error, want=%v, get=%v", errTsigBadTime, err)
+	}
+}
+
+// the response is signed with the MAC of the request, RFC 8945 section 5.3
+func TestTsigResponse(t *testing.T) {
+	key := testTsigKey(t)
+	request := signTsig(testUpdateMessage("tld.", nil, nil), key, nil, time.Now())
+	requestTsig := testReadTsig(t, request)
+
+	_, _, _, zoneEnd, err := wireReadQuestion(request, kDnsHeaderLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := signTsig(updateResponse(request, zoneEnd, 0), key, requestTsig.mac, time.Now())
+	tsig := testReadTsig(t, response)
+
+	expected := tsigMAC(key, requestTsig.mac, tsig.unsignedMessage(response), tsig.variables())
+	if string(expected) != string(tsig.mac) {
+		t.Errorf("response signature error")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/helmutkemper/dns"
+	"strings"
+)
+
+const kWireMaxPointers = 32
+
+var errWireFormat = errors.New("dns message format error")
+
+// resource record as found in the message, used by the messages the DNS server doesn't parse, like UPDATE
+type wireResource struct {
+	name        string
+	rrType      dns.Type
+	class       uint16
+	ttl         uint32
+	rdata       []byte
+	offset      int
+	rdataOffset int
+}
+
+// read a name at offset, following compression pointers. Returns the name in lower case, ended in point, and the
+// offset after the name
+func wireReadName(message []byte, offset int) (string, int, error) {
+	var labels []string
+	var next = -1
+	var pointers = 0
+
+	for {
+		if offset >= len(message) {
+			return "", 0, errWireFormat
+		}
+
+		length := int(message[offset])
+		switch {
+		case length == 0:
+			if next == -1 {
+				next = offset + 1
+			}
+
+			return strings.ToLower(strings.Join(labels, ".")) + ".", next, nil
+
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(message) || pointers > kWireMaxPointers {
+				return "", 0, errWireFormat
+			}
+
+			if next == -1 {
+				next = offset + 2
+			}
+
+			pointers++
+			offset = int(binary.BigEndian.Uint16(message[offset:]) & 0x3fff)
+
+		default:
+			if offset+1+length > len(message) {
+				return "", 0, errWireFormat
+			}
+
+			labels = append(labels, string(message[offset+1:offset+1+length]))
+			offset += length + 1
+		}
+	}
+}
+
+// read a question, or the zone of an UPDATE message, at offset
+func wireReadQuestion(message []byte, offset int) (name string, qType dns.Type, class uint16, next int, err error) {
+	name, offset, err = wireReadName(message, offset)
+	if err != nil {
+		return
+	}
+
+	if offset+4 > len(message) {
+		err = errWireFormat
+		return
+	}
+
+	qType = dns.Type(binary.BigEndian.Uint16(message[offset:]))
+	class = binary.BigEndian.Uint16(message[offset+2:])
+	next = offset + 4
+
+	return
+}
+
+// read a resource record at offset
+func wireReadResource(message []byte, offset int) (wireResource, int, error) {
+	var err error
+	var resource = wireResource{offset: offset}
+
+	resource.name, offset, err = wireReadName(message, offset)
+	if err != nil {
+		return resource, 0, err
+	}
+
+	if offset+10 > len(message) {
+		return resource, 0, errWireFormat
+	}
+
+	resource.rrType = dns.Type(binary.BigEndian.Uint16(message[offset:]))
+	resource.class = binary.BigEndian.Uint16(message[offset+2:])
+	resource.ttl = binary.BigEndian.Uint32(message[offset+4:])
+	length := int(binary.BigEndian.Uint16(message[offset+8:]))
+	offset += 10
+
+	if offset+length > len(message) {
+		return resource, 0, errWireFormat
+	}
+
+	resource.rdataOffset = offset
+	resource.rdata = message[offset : offset+length]
+
+	return resource, offset + length, nil
+}
+
+// read count resource records at offset
+func wireReadResourceList(message []byte, offset, count int) ([]wireResource, int, error) {
+	var err error
+	var resource wireResource
+	var list = make([]wireResource, 0, count)
+
+	for k := 0; k < count; k++ {
+		resource, offset, err = wireReadResource(message, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, resource)
+	}
+
+	return list, offset, nil
+}
+
+// SRV record of the rdata of a resource record
+func wireReadSRV(message []byte, resource wireResource) (*dns.SRV, error) {
+	if len(resource.rdata) < 7 {
+		return nil, errWireFormat
+	}
+
+	target, _, err := wireReadName(message, resource.rdataOffset+6)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dns.SRV{
+		Priority: int(binary.BigEndian.Uint16(resource.rdata)),
+		Weight:   int(binary.BigEndian.Uint16(resource.rdata[2:])),
+		Port:     int(binary.BigEndian.Uint16(resource.rdata[4:])),
+		Target:   target,
+	}, nil
+}
+
+// append a name without compression, in lower case, as used by signatures
+func wireAppendName(b []byte, name string) []byte {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+
+	return append(b, 0)
+}
+
+func wireAppendUint16(b []byte, value uint16) []byte {
+	return append(b, byte(value>>8), byte(value))
+}
+
+func wireAppendUint32(b []byte, value uint32) []byte {
+	return append(b, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+// append a 48 bits time, as used by TSIG
+func wireAppendUint48(b []byte, value uint64) []byte {
+	return append(b, byte(value>>40), byte(value>>32), byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
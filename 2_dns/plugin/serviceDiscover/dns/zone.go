@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"github.com/helmutkemper/dns"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TTL of the negative answers when the config file doesn't set negativeTtlSecond
+const kZoneDefaultNegativeTtlSecond = 60
+
+// decides if a record can be served for a query. Returning false hides the record from the answer.
+type recordFilter func(query *dns.Query, serviceName string, record dns.Record) bool
+
+// called after any change in the records list
+type changeListener func()
+
+// serviceZone is the record list served by the plugin. It works like dns.Zone, but it is thread safe and it allows the
+// plugin to decide, at query time, which records go to the answer.
+type serviceZone struct {
+	sync.RWMutex
+	origin     string
+	ttl        time.Duration
+	soa        *dns.SOA
+	records    map[string]map[dns.Type][]dns.Record
+	filters    []recordFilter
+	listeners  []changeListener
+	maxAnswers int
+}
+
+func newServiceZone(origin string, ttl time.Duration, soa *dns.SOA) *serviceZone {
+	return &serviceZone{
+		origin:  origin,
+		ttl:     ttl,
+		soa:     soa,
+		records: make(map[string]map[dns.Type][]dns.Record),
+	}
+}
+
+// add a filter used by ServeDNS to hide records from the answer
+func (el *serviceZone) AddFilter(filter recordFilter) {
+	el.Lock()
+	defer el.Unlock()
+
+	el.filters = append(el.filters, filter)
+}
+
+// largest number of records of each type in the answer. Zero answers all records
+func (el *serviceZone) SetMaxAnswers(maxAnswers int) {
+	el.Lock()
+	defer el.Unlock()
+
+	el.maxAnswers = maxAnswers
+}
+
+// add a function called after any change in the records list
+func (el *serviceZone) OnChange(listener changeListener) {
+	el.Lock()
+	defer el.Unlock()
+
+	el.listeners = append(el.listeners, listener)
+}
+
+func (el *serviceZone) changed() {
+	el.RLock()
+	listeners := el.listeners
+	el.RUnlock()
+
+	for _, listener := range listeners {
+		listener()
+	}
+}
+
+// set entire records list
+func (el *serviceZone) Set(serviceList map[string]map[dns.Type][]dns.Record) {
+	el.Lock()
+	el.records = make(map[string]map[dns.Type][]dns.Record)
+	for serviceName, v := range serviceList {
+		el.records[strings.ToLower(serviceName)] = v
+	}
+	el.Unlock()
+
+	el.changed()
+}
+
+// set records for service name
+func (el *serviceZone) SetKey(serviceName string, v map[dns.Type][]dns.Record) {
+	el.Lock()
+	el.records[strings.ToLower(serviceName)] = v
+	el.Unlock()
+
+	el.changed()
+}
+
+// set or append record for service name
+func (el *serviceZone) AppendRecordInKey(serviceName string, v dns.Record) {
+	el.Lock()
+	serviceName = strings.ToLower(serviceName)
+	if el.records[serviceName] == nil {
+		el.records[serviceName] = make(map[dns.Type][]dns.Record)
+	}
+
+	el.records[serviceName][v.Type()] = append(el.records[serviceName][v.Type()], v)
+	el.Unlock()
+
+	el.changed()
+}
+
+// remove record from service name
+func (el *serviceZone) DeleteRecordInKey(serviceName string, v dns.Record) {
+	el.Lock()
+	serviceName = strings.ToLower(serviceName)
+	list := el.records[serviceName][v.Type()]
+	for k := range list {
+		if reflect.DeepEqual(list[k], v) {
+			el.records[serviceName][v.Type()] = append(list[:k:k], list[k+1:]...)
+			break
+		}
+	}
+	el.Unlock()
+
+	el.changed()
+}
+
+// remove service name from records list
+func (el *serviceZone) DeleteKey(serviceName string) {
+	el.Lock()
+	delete(el.records, strings.ToLower(serviceName))
+	el.Unlock()
+
+	el.changed()
+}
+
+// get a copy of the records of a service name
+func (el *serviceZone) Get(serviceName string) map[dns.Type][]dns.Record {
+	el.RLock()
+	defer el.RUnlock()
+
+	ret := make(map[dns.Type][]dns.Record)
+	for recordType, list := range el.records[strings.ToLower(serviceName)] {
+		ret[recordType] = append([]dns.Record{}, list...)
+	}
+
+	return ret
+}
+
+// get all service names
+func (el *serviceZone) Names() []string {
+	el.RLock()
+	defer el.RUnlock()
+
+	ret := make([]string, 0, len(el.records))
+	for serviceName := range el.records {
+		ret = append(ret, serviceName)
+	}
+
+	return ret
+}
+
+// current SOA record. IncrementSerial() replaces the record instead of changing it, so the returned record can be read
+// after the lock is released
+func (el *serviceZone) SOA() *dns.SOA {
+	el.RLock()
+	defer el.RUnlock()
+
+	return el.soa
+}
+
+// increment the SOA serial number, so secondary servers know that the zone changed
+func (el *serviceZone) IncrementSerial() int {
+	el.Lock()
+	defer el.Unlock()
+
+	soa := *el.soa
+	soa.Serial++
+	el.soa = &soa
+
+	return soa.Serial
+}
+
+func (el *serviceZone) Origin() string {
+	return el.origin
+}
+
+func (el *serviceZone) TTL() time.Duration {
+	return el.ttl
+}
+
+// TTL of the negative answers
+func (el *serviceZone) NegativeTTL() time.Duration {
+	el.RLock()
+	defer el.RUnlock()
+
+	return negativeTTL(el.ttl, el.soa)
+}
+
+// TTL of the negative answers: the smaller of the TTL and the minimum field of the SOA, RFC 2308 section 5
+func negativeTTL(ttl time.Duration, soa *dns.SOA) time.Duration {
+	if soa != nil && soa.MinTTL > 0 && soa.MinTTL < ttl {
+		return soa.MinTTL
+	}
+
+	return ttl
+}
+
+// converts a service name into the fully qualified name served by the zone. ex.: "node" to "node.tld."
+func (el *serviceZone) Fqdn(serviceName string) string {
+	if serviceName == "" {
+		return el.origin
+	}
+
+	return serviceName + "." + el.origin
+}
+
+// all records served by the zone, sorted by name, used by zone transfers
+func (el *serviceZone) Transfer(query *dns.Query) []dns.Resource {
+	var ret []dns.Resource
+	var nameList []string
+
+	el.RLock()
+	defer el.RUnlock()
+
+	for serviceName := range el.records {
+		nameList = append(nameList, serviceName)
+	}
+	sort.Strings(nameList)
+
+	for _, serviceName := range nameList {
+		var typeList []int
+		for recordType := range el.records[serviceName] {
+			typeList = append(typeList, int(recordType))
+		}
+		sort.Ints(typeList)
+
+		for _, recordType := range typeList {
+			for _, record := range el.records[serviceName][dns.Type(recordType)] {
+				if el.pass(query, serviceName, record) {
+					ret = append(ret, dns.Resource{Name: el.Fqdn(serviceName), Class: dns.ClassIN, TTL: el.ttl, Record: record})
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// converts a fully qualified query name into the service name used as key of the records list
+func (el *serviceZone) serviceName(fqdn string) (string, bool) {
+	fqdn = strings.ToLower(fqdn)
+	if fqdn == el.origin {
+		return "", true
+	}
+
+	if !strings.HasSuffix(fqdn, "."+el.origin) {
+		return "", false
+	}
+
+	return strings.TrimSuffix(fqdn, "."+el.origin), true
+}
+
+func (el *serviceZone) pass(query *dns.Query, serviceName string, record dns.Record) bool {
+	for _, filter := range el.filters {
+		if filter(query, serviceName, record) == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+// result of a question, from the best to the worst
+const (
+	kZoneAnswer = iota
+	kZoneNoData
+	kZoneNxDomain
+)
+
+// dns.Handler interface. Names with a CNAME record are answered with the CNAME and the records of its target, when the
+// target is in the zone, RFC 1034 section 3.6.2.
+// Negative answers carry the SOA in the authority section, with the negative TTL, RFC 2308 section 3. A name without
+// records of the question type is NODATA, rcode NOERROR without answers, and a name without any record is NXDOMAIN.
+func (el *serviceZone) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+	var result = kZoneAnswer
+
+	w.Authoritative(true)
+
+	el.RLock()
+	defer el.RUnlock()
+
+	for _, question := range r.Questions {
+		if questionResult := el.resolve(w, r, question); questionResult > result {
+			result = questionResult
+		}
+	}
+
+	if len(r.Questions) == 0 {
+		result = kZoneNxDomain
+	}
+
+	if result == kZoneAnswer {
+		return
+	}
+
+	if result == kZoneNxDomain {
+		w.Status(dns.NXDomain)
+	}
+
+	if el.soa != nil {
+		w.Authority(el.origin, negativeTTL(el.ttl, el.soa), el.soa)
+	}
+}
+
+// answer the question, following the CNAME records inside the zone. The result is the one of the last name of the
+// chain, so an alias of a missing name is NXDOMAIN, RFC 6604 section 3
+func (el *serviceZone) resolve(w dns.MessageWriter, r *dns.Query, question dns.Question) int {
+	var name = question.Name
+	var visited = make(map[string]bool)
+
+	for hop := 0; hop <= kZoneMaxAliasHops; hop++ {
+		serviceName, inZone := el.serviceName(name)
+		if !inZone {
+			// the target of an alias out of the zone is resolved by the client
+			if hop != 0 {
+				return kZoneAnswer
+			}
+			return kZoneNxDomain
+		}
+
+		if visited[serviceName] {
+			break
+		}
+		visited[serviceName] = true
+
+		if el.answer(w, r, question, name, serviceName) {
+			return kZoneAnswer
+		}
+
+		cname := el.alias(r, serviceName)
+		if cname == nil || question.Type == dns.TypeCNAME {
+			if el.exists(serviceName) {
+				return kZoneNoData
+			}
+			return kZoneNxDomain
+		}
+
+		w.Answer(name, el.ttl, cname)
+		name = cname.CNAME
+	}
+
+	// alias loop or chain too long. The answer has the CNAME records followed so far
+	return kZoneAnswer
+}
+
+// true when the name has records of any type, filtered or not, or has names below it, the empty non-terminals of
+// RFC 8020. The origin always exists
+func (el *serviceZone) exists(serviceName string) bool {
+	if serviceName == "" {
+		return true
+	}
+
+	for _, list := range el.records[serviceName] {
+		if len(list) != 0 {
+			return true
+		}
+	}
+
+	for name, types := range el.records {
+		if !strings.HasSuffix(name, "."+serviceName) {
+			continue
+		}
+
+		for _, list := range types {
+			if len(list) != 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// write the records of the question type found in the service name. When the name has more than maxAnswers records,
+// a random subset of them is written, so the clients spread over all instances
+func (el *serviceZone) answer(w dns.MessageWriter, r *dns.Query, question dns.Question, name, serviceName string) bool {
+	var answers []dns.Record
+
+	// the SOA of the zone is served at the origin, validators ask for it
+	if serviceName == "" && question.Type == dns.TypeSOA && el.soa != nil {
+		answers = append(answers, el.soa)
+	}
+
+	for _, record := range el.records[serviceName][question.Type] {
+		if el.pass(r, serviceName, record) {
+			answers = append(answers, record)
+		}
+	}
+
+	if el.maxAnswers != 0 && len(answers) > el.maxAnswers {
+		rand.Shuffle(len(answers), func(i, j int) {
+			answers[i], answers[j] = answers[j], answers[i]
+		})
+		answers = answers[:el.maxAnswers]
+	}
+
+	for _, record := range answers {
+		w.Answer(name, el.ttl, record)
+	}
+
+	// instance metadata goes alongside the SRV records, as DNS-SD does. Only the metadata of the instances answered
+	if question.Type == dns.TypeSRV && len(answers) != 0 {
+		var instances = make(map[string]bool)
+		for _, record := range answers {
+			if srv, ok := record.(*dns.SRV); ok {
+				instances[net.JoinHostPort(srv.Target, strconv.Itoa(srv.Port))] = true
+			}
+		}
+
+		for _, record := range el.records[serviceName][dns.TypeTXT] {
+			if !el.pass(r, serviceName, record) {
+				continue
+			}
+
+			if txt, ok := record.(*dns.TXT); ok {
+				if target, port, ok := txtInstance(txt); ok && !instances[net.JoinHostPort(target, strconv.Itoa(port))] {
+					continue
+				}
+			}
+
+			w.Additional(name, el.ttl, record)
+		}
+	}
+
+	return len(answers) != 0
+}
+
+// CNAME record of the service name, nil for names without alias
+func (el *serviceZone) alias(r *dns.Query, serviceName string) *dns.CNAME {
+	for _, record := range el.records[serviceName][dns.TypeCNAME] {
+		if cname, ok := record.(*dns.CNAME); ok && el.pass(r, serviceName, record) {
+			return cname
+		}
+	}
+
+	return nil
+}
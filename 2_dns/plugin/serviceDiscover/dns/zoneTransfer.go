@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/helmutkemper/dns"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kTypeIXFR dns.Type = 251
+	kTypeAXFR dns.Type = 252
+
+	kDnsOpCodeNotify = 4
+
+	kNotifyDebounce = time.Second
+	kNotifyTimeOut  = 2 * time.Second
+	kNotifyRetries  = 3
+
+	// largest size of each message of a transfer. A tcp message can't pass 64KB, so larger zones are sent in many
+	// messages, RFC 5936 section 2.2
+	kTransferMessageLength = 16384
+)
+
+// zone transfer configuration
+//
+//   allow:  networks of the secondary servers allowed to transfer the zones. ex.: ["10.0.0.0/8", "172.18.0.10/32"]
+//   notify: secondary servers notified when the zone changes. ex.: ["10.0.0.2:53"]
+type zoneTransferConfig struct {
+	Allow  []string
+	Notify []string
+}
+
+// zoneTransfer answers AXFR and IXFR queries of allowed secondary servers and sends NOTIFY to them when the records
+// change. There is no history of changes, so IXFR is answered with the whole zone, as RFC 1995 allows, unless the
+// secondary is already up to date.
+// Transfers are only accepted over tcp, by the transferListener, and the records are split in messages of up to
+// kTransferMessageLength bytes. The zone is sent with the health and split horizon filters of the secondary; the health
+// checker changes the serial when a target goes down or up, and views only change with the records.
+type zoneTransfer struct {
+	allow   []*net.IPNet
+	notify  []string
+	zone    *serviceZone
+	reverse *reverseZone
+	onError func(error)
+	onLog   func(string)
+	pending chan struct{}
+}
+
+func newZoneTransfer(config zoneTransferConfig, zone *serviceZone, reverse *reverseZone, onError func(error), onLog func(string)) (*zoneTransfer, error) {
+	var transfer = &zoneTransfer{
+		notify:  config.Notify,
+		zone:    zone,
+		reverse: reverse,
+		onError: onError,
+		onLog:   onLog,
+		pending: make(chan struct{}, 1),
+	}
+
+	for _, cidr := range config.Allow {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		transfer.allow = append(transfer.allow, network)
+	}
+
+	for _, secondary := range config.Notify {
+		_, _, err := net.SplitHostPort(secondary)
+		if err != nil {
+			return nil, errors.New("zone transfer notify address " + secondary + " must be host:port")
+		}
+	}
+
+	return transfer, nil
+}
+
+func isZoneTransfer(question dns.Question) bool {
+	return question.Type == kTypeAXFR || question.Type == kTypeIXFR
+}
+
+// transfers are only allowed over tcp, from the networks in the allow list
+func (el *zoneTransfer) allowed(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range el.allow {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serial number sent by the secondary in the authority section of an IXFR query
+func (el *zoneTransfer) clientSerial(r *dns.Query) (int, bool) {
+	for _, resource := range r.Authorities {
+		if soa, ok := resource.Record.(*dns.SOA); ok {
+			return soa.Serial, true
+		}
+	}
+
+	return 0, false
+}
+
+// dns.Handler interface. Transfers over tcp are answered by the transferListener before they reach the server, so the
+// queries that reach the handler came by udp and are refused
+func (el *zoneTransfer) ServeDNS(ctx context.Context, w dns.MessageWriter, r *dns.Query) {
+	el.onLog(fmt.Sprintf("zone transfer of %v refused to %v", r.Questions[0].Name, r.RemoteAddr))
+	w.Status(dns.Refused)
+}
+
+// length of the resource in a message, without name compression
+func resourceLength(resource dns.Resource) int {
+	rdata, _ := resource.Record.Pack(nil, nil)
+
+	// labels of the name, root label, type, class, ttl and rdata length
+	return len(resource.Name) + 2 + 10 + len(rdata)
+}
+
+// response messages of the transfer query: the SOA, the records and the SOA again, split in messages of up to
+// kTransferMessageLength bytes. Only the first message has the question
+func (el *zoneTransfer) messages(r *dns.Query) []*dns.Message {
+	var records []dns.Resource
+	var question = r.Questions[0]
+	var origin = strings.ToLower(question.Name)
+	var soa = el.zone.SOA()
+	var ttl = el.zone.TTL()
+
+	reply := func() *dns.Message {
+		return &dns.Message{ID: r.ID, Response: true, OpCode: r.OpCode, Authoritative: true}
+	}
+
+	first := reply()
+	first.Questions = r.Questions
+
+	if !el.allowed(r.RemoteAddr) {
+		el.onLog(fmt.Sprintf("zone transfer of %v refused to %v", question.Name, r.RemoteAddr))
+		first.Authoritative = false
+		first.RCode = dns.Refused
+		return []*dns.Message{first}
+	}
+
+	switch origin {
+	case el.zone.Origin():
+		records = el.zone.Transfer(r)
+	case kReverseZoneIPv4, kReverseZoneIPv6:
+		records = el.reverse.Transfer(origin)
+	default:
+		first.Authoritative = false
+		first.RCode = dns.Refused
+		return []*dns.Message{first}
+	}
+
+	soaResource := dns.Resource{Name: question.Name, Class: dns.ClassIN, TTL: ttl, Record: soa}
+
+	if question.Type == kTypeIXFR {
+		serial, ok := el.clientSerial(r)
+		if ok && serial == soa.Serial {
+			first.Answers = []dns.Resource{soaResource}
+			return []*dns.Message{first}
+		}
+	}
+
+	records = append(append([]dns.Resource{soaResource}, records...), soaResource)
+
+	messages := []*dns.Message{first}
+	length := kDnsHeaderLength + len(question.Name) + 2 + 4
+	for _, resource := range records {
+		current := messages[len(messages)-1]
+		size := resourceLength(resource)
+
+		if len(current.Answers) != 0 && length+size > kTransferMessageLength {
+			current = reply()
+			messages = append(messages, current)
+			length = kDnsHeaderLength
+		}
+
+		current.Answers = append(current.Answers, resource)
+		length += size
+	}
+
+	el.onLog(fmt.Sprintf("zone transfer of %v serial %v to %v in %v messages", question.Name, soa.Serial, r.RemoteAddr, len(messages)))
+	return messages
+}
+
+// changeListener of the service zone. Changes made in a short time are grouped in one NOTIFY
+func (el *zoneTransfer) Changed() {
+	select {
+	case el.pending <- struct{}{}:
+	default:
+	}
+}
+
+// send NOTIFY to the secondary servers forever, for the service zone and for the reverse zones that have, or had at the
+// last NOTIFY, PTR records. The reverse zones are built from the service zone, so they change with it
+func (el *zoneTransfer) Run() {
+	var notified = make(map[string]bool)
+
+	for range el.pending {
+		time.Sleep(kNotifyDebounce)
+
+		origins := []string{el.zone.Origin()}
+		for _, origin := range []string{kReverseZoneIPv4, kReverseZoneIPv6} {
+			hasRecords := len(el.reverse.Transfer(origin)) != 0
+			if hasRecords || notified[origin] {
+				origins = append(origins, origin)
+			}
+			notified[origin] = hasRecords
+		}
+
+		for _, origin := range origins {
+			for _, secondary := range el.notify {
+				go el.sendNotify(secondary, origin)
+			}
+		}
+	}
+}
+
+func (el *zoneTransfer) sendNotify(secondary, origin string) {
+	var err error
+
+	for retry := 0; retry < kNotifyRetries; retry++ {
+		err = el.notifyOnce(secondary, origin)
+		if err == nil {
+			return
+		}
+	}
+
+	el.onError(errors.New("notify " + secondary + " error: " + err.Error()))
+}
+
+func (el *zoneTransfer) notifyOnce(secondary, origin string) error {
+	var response = make([]byte, 512)
+	var random = make([]byte, 2)
+
+	// an id that can't be guessed, so spoofed responses aren't accepted, RFC 5452 section 4.3
+	_, err := rand.Read(random)
+	if err != nil {
+		return err
+	}
+	id := binary.BigEndian.Uint16(random)
+
+	conn, err := net.DialTimeout("udp", secondary, kNotifyTimeOut)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(kNotifyTimeOut))
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(notifyPacket(id, origin))
+	if err != nil {
+		return err
+	}
+
+	n, err := conn.Read(response)
+	if err != nil {
+		return err
+	}
+
+	if n < kDnsHeaderLength || binary.BigEndian.Uint16(response) != id || response[2]&0x80 == 0 {
+		return errors.New("invalid notify response")
+	}
+
+	if rcode := response[3] & 0x0f; rcode != 0 {
+		return errors.New("notify refused with " + rcodeName(dns.RCode(rcode)))
+	}
+
+	return nil
+}
+
+// NOTIFY message (RFC 1996) with the SOA question of the zone
+func notifyPacket(id uint16, origin string) []byte {
+	var packet = make([]byte, kDnsHeaderLength)
+
+	binary.BigEndian.PutUint16(packet, id)
+	// opcode NOTIFY and AA flag
+	packet[2] = kDnsOpCodeNotify<<3 | 0x04
+	// one question
+	packet[5] = 1
+
+	for _, label := range strings.Split(strings.TrimSuffix(origin, "."), ".") {
+		packet = append(packet, byte(len(label)))
+		packet = append(packet, label...)
+	}
+
+	// root label, type SOA and class IN
+	return append(packet, 0, 0, byte(dns.TypeSOA), 0, byte(dns.ClassIN))
+}
+
+// transferListener answers the AXFR and IXFR queries of the tcp connections with many messages, as dns.Server sends
+// one message for each query. Other queries reach the server
+type transferListener struct {
+	net.Listener
+	transfer *zoneTransfer
+}
+
+func (el *transferListener) Accept() (net.Conn, error) {
+	conn, err := el.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &transferConn{Conn: conn, transfer: el.transfer, reader: bufio.NewReader(conn)}, nil
+}
+
+// tcp connection read by dns.Server. Each query is read with the length prefix, RFC 1035 section 4.2.2; transfer
+// queries are answered here and the other queries are passed to the server
+type transferConn struct {
+	net.Conn
+	transfer *zoneTransfer
+	reader   *bufio.Reader
+	pending  []byte
+	// the server answers the queries of the connection in parallel
+	writeLock sync.Mutex
+}
+
+func (el *transferConn) Read(b []byte) (int, error) {
+	for len(el.pending) == 0 {
+		var frame = make([]byte, 2)
+
+		_, err := io.ReadFull(el.reader, frame)
+		if err != nil {
+			return 0, err
+		}
+
+		frame = append(frame, make([]byte, binary.BigEndian.Uint16(frame))...)
+		_, err = io.ReadFull(el.reader, frame[2:])
+		if err != nil {
+			return 0, err
+		}
+
+		answered, err := el.answerTransfer(frame[2:])
+		if err != nil {
+			return 0, err
+		}
+
+		if !answered {
+			el.pending = frame
+		}
+	}
+
+	n := copy(b, el.pending)
+	el.pending = el.pending[n:]
+
+	return n, nil
+}
+
+func (el *transferConn) Write(b []byte) (int, error) {
+	el.writeLock.Lock()
+	defer el.writeLock.Unlock()
+
+	return el.Conn.Write(b)
+}
+
+// writes the messages of the transfer, when the query is AXFR or IXFR. Returns false for the other queries
+func (el *transferConn) answerTransfer(packet []byte) (bool, error) {
+	var message = new(dns.Message)
+
+	if _, err := message.Unpack(packet); err != nil || message.Response || len(message.Questions) != 1 {
+		return false, nil
+	}
+
+	if !isZoneTransfer(message.Questions[0]) {
+		return false, nil
+	}
+
+	el.writeLock.Lock()
+	defer el.writeLock.Unlock()
+
+	for _, response := range el.transfer.messages(&dns.Query{Message: message, RemoteAddr: el.RemoteAddr()}) {
+		data, err := response.Pack(make([]byte, 2), true)
+		if err != nil {
+			el.transfer.onError(err)
+			return true, err
+		}
+
+		binary.BigEndian.PutUint16(data, uint16(len(data)-2))
+		if _, err = el.Conn.Write(data); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
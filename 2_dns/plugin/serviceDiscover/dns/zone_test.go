@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"github.com/helmutkemper/dns"
+	"testing"
+	"time"
+)
+
+// keeps the response written by a handler
+type testWriter struct {
+	response dns.Message
+}
+
+func (el *testWriter) Authoritative(aa bool) { el.response.Authoritative = aa }
+func (el *testWriter) Recursion(ra bool)     { el.response.RecursionAvailable = ra }
+func (el *testWriter) Status(rcode dns.RCode) {
+	el.response.RCode = rcode
+}
+
+func (el *testWriter) Answer(fqdn string, ttl time.Duration, record dns.Record) {
+	el.response.Answers = append(el.response.Answers, dns.Resource{Name: fqdn, Class: dns.ClassIN, TTL: ttl, Record: record})
+}
+
+func (el *testWriter) Authority(fqdn string, ttl time.Duration, record dns.Record) {
+	el.response.Authorities = append(el.response.Authorities, dns.Resource{Name: fqdn, Class: dns.ClassIN, TTL: ttl, Record: record})
+}
+
+func (el *testWriter) Additional(fqdn string, ttl time.Duration, record dns.Record) {
+	el.response.Additionals = append(el.response.Additionals, dns.Resource{Name: fqdn, Class: dns.ClassIN, TTL: ttl, Record: record})
+}
+
+func (el *testWriter) Recur(context.Context) (*dns.Message, error) { return nil, nil }
+func (el *testWriter) Reply(context.Context) error                 { return nil }
+
+func testZone() *serviceZone {
+	zone := newServiceZone("tld.", time.Hour, &dns.SOA{NS: "dns.tld.", MBox: "hostmaster.tld.", Serial: 1, MinTTL: 30 * time.Second})
+	zone.Set(map[string]map[dns.Type][]dns.Record{
+		"node": {
+			dns.TypeSRV: {&dns.SRV{Target: "node1.tld.", Port: 8080}},
+			dns.TypeTXT: {&dns.TXT{TXT: []string{"target=node1.tld.", "port=8080"}}},
+		},
+		"node1":          {dns.TypeA: {&dns.A{A: []byte{10, 0, 0, 1}}}},
+		"empty":          {dns.TypeSRV: {}},
+		"_http._tcp.api": {dns.TypeSRV: {&dns.SRV{Target: "node1.tld.", Port: 80}}},
+		"db":             {dns.TypeCNAME: {&dns.CNAME{CNAME: "node1.tld."}}},
+		"old":            {dns.TypeCNAME: {&dns.CNAME{CNAME: "gone.tld."}}},
+	})
+
+	return zone
+}
+
+func testServe(zone *serviceZone, name string, recordType dns.Type) dns.Message {
+	w := &testWriter{}
+	zone.ServeDNS(context.Background(), w, &dns.Query{Message: &dns.Message{Questions: []dns.Question{{Name: name, Type: recordType, Class: dns.ClassIN}}}})
+
+	return w.response
+}
+
+// negative answers have no answer records and the SOA of the zone, with the negative TTL, in the authority section
+func testNegative(t *testing.T, response dns.Message, rcode dns.RCode) {
+	if response.RCode != rcode {
+		t.Errorf("rcode error, want=%v, get=%v", rcode, response.RCode)
+	}
+
+	if len(response.Answers) != 0 {
+		t.Errorf("answer section error, want=0 records, get=%v", len(response.Answers))
+	}
+
+	if len(response.Authorities) != 1 {
+		t.Fatalf("authority section error, want=1 record, get=%v", len(response.Authorities))
+	}
+
+	authority := response.Authorities[0]
+	if _, ok := authority.Record.(*dns.SOA); !ok || authority.Name != "tld." {
+		t.Errorf("authority section error, want=SOA of tld., get=%v of %v", typeName(authority.Record.Type()), authority.Name)
+	}
+
+	if authority.TTL != 30*time.Second {
+		t.Errorf("negative TTL error, want=30s, get=%v", authority.TTL)
+	}
+}
+
+func TestServiceZoneAnswer(t *testing.T) {
+	response := testServe(testZone(), "node.tld.", dns.TypeSRV)
+
+	if response.RCode != dns.NoError || len(response.Answers) != 1 || len(response.Authorities) != 0 {
+		t.Errorf("answer error, get=%+v", response)
+	}
+
+	if len(response.Additionals) != 1 {
+		t.Errorf("additional section error, want=1 TXT record, get=%v", len(response.Additionals))
+	}
+}
+
+func TestServiceZoneNxDomain(t *testing.T) {
+	testNegative(t, testServe(testZone(), "missing.tld.", dns.TypeSRV), dns.NXDomain)
+}
+
+func TestServiceZoneNoDataOtherType(t *testing.T) {
+	testNegative(t, testServe(testZone(), "node.tld.", dns.TypeA), dns.NoError)
+}
+
+func TestServiceZoneNoDataEmptyNonTerminal(t *testing.T) {
+	testNegative(t, testServe(testZone(), "_tcp.api.tld.", dns.TypeSRV), dns.NoError)
+}
+
+func TestServiceZoneNoDataOrigin(t *testing.T) {
+	testNegative(t, testServe(testZone(), "tld.", dns.TypeSRV), dns.NoError)
+}
+
+// a service without instances has no records and doesn't exist
+func TestServiceZoneNxDomainWithoutRecords(t *testing.T) {
+	testNegative(t, testServe(testZone(), "empty.tld.", dns.TypeSRV), dns.NXDomain)
+}
+
+// a name with all records hidden by a filter still exists
+func TestServiceZoneNoDataFiltered(t *testing.T) {
+	zone := testZone()
+	zone.AddFilter(func(query *dns.Query, serviceName string, record dns.Record) bool {
+		return serviceName != "node"
+	})
+
+	testNegative(t, testServe(zone, "node.tld.", dns.TypeSRV), dns.NoError)
+}
+
+func TestServiceZoneAliasAnswer(t *testing.T) {
+	response := testServe(testZone(), "db.tld.", dns.TypeA)
+
+	if response.RCode != dns.NoError || len(response.Answers) != 2 {
+		t.Fatalf("alias answer error, get=%+v", response)
+	}
+
+	if _, ok := response.Answers[0].Record.(*dns.CNAME); !ok {
+		t.Errorf("alias answer error, want=CNAME first, get=%v", typeName(response.Answers[0].Record.Type()))
+	}
+}
+
+// an alias of a missing name is NXDOMAIN with the CNAME in the answer, RFC 6604
+func TestServiceZoneAliasNxDomain(t *testing.T) {
+	response := testServe(testZone(), "old.tld.", dns.TypeA)
+
+	if response.RCode != dns.NXDomain || len(response.Answers) != 1 || len(response.Authorities) != 1 {
+		t.Errorf("alias of a missing name error, get=%+v", response)
+	}
+}
+
+func TestServiceZoneAliasNoData(t *testing.T) {
+	response := testServe(testZone(), "db.tld.", dns.TypeSRV)
+
+	if response.RCode != dns.NoError || len(response.Answers) != 1 || len(response.Authorities) != 1 {
+		t.Errorf("alias of a name without the type error, get=%+v", response)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	if ttl := negativeTTL(time.Hour, &dns.SOA{MinTTL: time.Minute}); ttl != time.Minute {
+		t.Errorf("negativeTTL error, want=1m0s, get=%v", ttl)
+	}
+
+	if ttl := negativeTTL(10*time.Second, &dns.SOA{MinTTL: time.Minute}); ttl != 10*time.Second {
+		t.Errorf("negativeTTL error, want=10s, get=%v", ttl)
+	}
+
+	if ttl := negativeTTL(time.Hour, &dns.SOA{}); ttl != time.Hour {
+		t.Errorf("negativeTTL error, want=1h0m0s, get=%v", ttl)
+	}
+}
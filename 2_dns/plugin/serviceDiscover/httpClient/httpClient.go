@@ -0,0 +1,319 @@
+// Go client of the http api of the Ben Burkert DNS compatible http server plugin. The api is described by the
+// OpenAPI document served in /openapi.json
+//
+//   client := httpClient.New("https://10.0.0.1:8080", nil)
+//   client.SetToken("c2VjcmV0LXRva2Vu")
+//
+//   instances, err := client.Register("node", httpClient.RegisterData{Port: 8080, Target: "192.168.10.1."})
+package httpClient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const kClientTimeoutSecond = 30
+
+// data sent to register, replace and deregister an instance. A blank target is the address of the client
+type RegisterData struct {
+	Port     int               `json:"port"`
+	Target   string            `json:"target,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	View     string            `json:"view,omitempty"`
+}
+
+// instance of a service, served as SRV record
+type Instance struct {
+	Priority int
+	Weight   int
+	Port     int
+	Target   string
+	Metadata map[string]string `json:",omitempty"`
+	View     string            `json:",omitempty"`
+}
+
+// service of the list, the batch and the watch. Aliases have the target in Alias and no instances
+type Service struct {
+	Name      string
+	Alias     string     `json:",omitempty"`
+	Instances []Instance `json:",omitempty"`
+}
+
+// filters and page of List(). Blank values aren't sent
+type ListQuery struct {
+	Prefix string
+	Target string
+	Port   int
+	Offset int
+	Limit  int
+}
+
+// change sent by Watch(). Type is "put" or "delete", deleted services have the name only
+type Event struct {
+	Type    string
+	Service Service
+}
+
+// error answered by the api, with the http status
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (el *Error) Error() string {
+	return strconv.Itoa(el.Status) + " " + el.Message
+}
+
+// output format of the api
+type output struct {
+	Meta struct {
+		TotalCount int
+		Success    bool
+		Error      string
+	}
+	Objects json.RawMessage
+}
+
+// Client of the api. Use New()
+type Client struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// client of the api in the url, ex.: "http://127.0.0.1:8080". Without http client, a client with timeout is used
+func New(url string, client *http.Client) *Client {
+	if client == nil {
+		client = &http.Client{Timeout: kClientTimeoutSecond * time.Second}
+	}
+
+	return &Client{url: strings.TrimSuffix(url, "/"), client: client}
+}
+
+// bearer token sent in every request. It must belong to an identity of the policy file of the server
+func (el *Client) SetToken(token string) {
+	el.token = token
+}
+
+func (el *Client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var err error
+	var reader io.Reader
+
+	if body != nil {
+		var jsonData []byte
+
+		jsonData, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequest(method, el.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if el.token != "" {
+		req.Header.Set("Authorization", "Bearer "+el.token)
+	}
+
+	return req, nil
+}
+
+// send the request and decode the objects of the answer. Returns the total count of the answer
+func (el *Client) do(method, path string, body interface{}, objects interface{}) (int, error) {
+	var out output
+
+	req, err := el.newRequest(context.Background(), method, path, body)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := el.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	jsonData, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	err = json.Unmarshal(jsonData, &out)
+	if err != nil {
+		return 0, &Error{Status: resp.StatusCode, Message: "unexpected answer: " + strings.TrimSpace(string(jsonData))}
+	}
+
+	if resp.StatusCode != http.StatusOK || !out.Meta.Success {
+		return 0, &Error{Status: resp.StatusCode, Message: out.Meta.Error}
+	}
+
+	if objects != nil && len(out.Objects) != 0 {
+		err = json.Unmarshal(out.Objects, objects)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return out.Meta.TotalCount, nil
+}
+
+// path of the name, ex.: /service/node
+func namePath(endpoint, name string) string {
+	return "/" + endpoint + "/" + url.PathEscape(name)
+}
+
+// register an instance of the service, or update the metadata and the view of a registered instance. Returns the
+// instances of the service
+func (el *Client) Register(name string, data RegisterData) ([]Instance, error) {
+	var instances []Instance
+
+	_, err := el.do(http.MethodPost, namePath("service", name), &data, &instances)
+	return instances, err
+}
+
+// replace the instance set of the service in one write. An empty list deregisters the service
+func (el *Client) Replace(name string, data []RegisterData) ([]Instance, error) {
+	var instances []Instance
+
+	if data == nil {
+		data = make([]RegisterData, 0)
+	}
+
+	_, err := el.do(http.MethodPut, namePath("service", name), &data, &instances)
+	return instances, err
+}
+
+// deregister the instance of the target and port. Returns the instances left
+func (el *Client) Deregister(name, target string, port int) ([]Instance, error) {
+	var instances []Instance
+
+	_, err := el.do(http.MethodDelete, namePath("service", name), &RegisterData{Port: port, Target: target}, &instances)
+	return instances, err
+}
+
+// instances of the service. Unknown services return an *Error with status 404
+func (el *Client) Get(name string) ([]Instance, error) {
+	var instances []Instance
+
+	_, err := el.do(http.MethodGet, namePath("service", name), nil, &instances)
+	return instances, err
+}
+
+// services of the query, sorted by name, and the number of services found before the offset and the limit
+func (el *Client) List(query ListQuery) ([]Service, int, error) {
+	var services []Service
+	var values = url.Values{}
+
+	if query.Prefix != "" {
+		values.Set("prefix", query.Prefix)
+	}
+	if query.Target != "" {
+		values.Set("target", query.Target)
+	}
+	if query.Port != 0 {
+		values.Set("port", strconv.Itoa(query.Port))
+	}
+	if query.Offset != 0 {
+		values.Set("offset", strconv.Itoa(query.Offset))
+	}
+	if query.Limit != 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	path := "/service"
+	if len(values) != 0 {
+		path += "?" + values.Encode()
+	}
+
+	total, err := el.do(http.MethodGet, path, nil, &services)
+	return services, total, err
+}
+
+// calls onEvent with the current services and then with each change, until the context is done or the stream ends.
+// A blank name watches the services of the prefix. Use an http client without timeout, the stream doesn't end
+func (el *Client) Watch(ctx context.Context, name, prefix string, onEvent func(Event)) error {
+	var event Event
+	var path = "/watch"
+
+	if name != "" {
+		path = namePath("watch", name)
+	} else if prefix != "" {
+		path += "?" + url.Values{"prefix": {prefix}}.Encode()
+	}
+
+	req, err := el.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := el.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var out output
+
+		jsonData, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(jsonData, &out) != nil {
+			out.Meta.Error = strings.TrimSpace(string(jsonData))
+		}
+
+		return &Error{Status: resp.StatusCode, Message: out.Meta.Error}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		// end of the event
+		case line == "":
+			if event.Type != "" {
+				onEvent(event)
+			}
+			event = Event{}
+
+		case strings.HasPrefix(line, "event:"):
+			event.Type = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+
+		case strings.HasPrefix(line, "data:"):
+			err = json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event.Service)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("watch stream closed by the server")
+}
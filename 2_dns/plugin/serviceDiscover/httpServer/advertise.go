@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+const kTargetMaxLength = 253
+
+// address published as SRV target by SelfRegister()
+//
+//   address:   optional. name or ip published as target, ex.: "dns.example.com" or "10.0.0.1"
+//   interface: optional. network interface of the published address, ex.: "eth0". Used when address is blank
+//
+//   Without both, the bind address is published or, when the listener is bound to all interfaces, the first global
+//   address of the interfaces that are up.
+type advertiseConfig struct {
+	Address   string
+	Interface string
+}
+
+// check if the target can be served as SRV target: a name of letters, digits, '-' and '_' or an ip, ended in point.
+// ex.: "dns.example.com.", "mongodb.", "10.0.0.1." or "fd00::1."
+func validateTarget(target string) error {
+	if !strings.HasSuffix(target, ".") || target == "." {
+		return errors.New("target " + target + " must be a name or an ip ended in point")
+	}
+
+	name := strings.TrimSuffix(target, ".")
+	if net.ParseIP(name) != nil {
+		return nil
+	}
+
+	if len(name) > kTargetMaxLength {
+		return errors.New("target " + target + " is too long. names must have up to 253 bytes")
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return errors.New("target " + target + " is not a valid name or ip")
+		}
+
+		for _, char := range label {
+			if (char < 'a' || char > 'z') && (char < 'A' || char > 'Z') && (char < '0' || char > '9') && char != '-' && char != '_' {
+				return errors.New("target " + target + " is not a valid name or ip")
+			}
+		}
+	}
+
+	return nil
+}
+
+// check the advertise section. The interface must exist when the plugin loads
+func (el *advertiseConfig) check() error {
+	if el.Address != "" {
+		return validateTarget(strings.TrimSuffix(el.Address, ".") + ".")
+	}
+
+	if el.Interface != "" {
+		if _, err := net.InterfaceByName(el.Interface); err != nil {
+			return errors.New("advertise interface " + el.Interface + " not found: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// SRV target published by SelfRegister(), ended in point: the advertise address, the address of the advertise
+// interface, the bind address or, when the listener is bound to all interfaces, the external ip
+func (el *HttpServer) advertiseTarget() (string, error) {
+	var err error
+	var address string
+
+	switch {
+	case el.advertise.Address != "":
+		address = el.advertise.Address
+
+	case el.advertise.Interface != "":
+		var iFace *net.Interface
+
+		iFace, err = net.InterfaceByName(el.advertise.Interface)
+		if err != nil {
+			return "", err
+		}
+
+		address, err = globalAddress([]net.Interface{*iFace})
+		if err != nil {
+			return "", errors.New("advertise interface " + el.advertise.Interface + ": " + err.Error())
+		}
+
+	default:
+		address, err = el.requestAddress()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	target := strings.TrimSuffix(address, ".") + "."
+	return target, validateTarget(target)
+}
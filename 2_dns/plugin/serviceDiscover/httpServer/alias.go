@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/helmutkemper/communsTypesForGolangPlugin"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// longest alias chain accepted, the same limit used by the DNS plugin
+const kAliasMaxHops = 8
+
+// data input format from endpoint name alias
+type alias struct {
+	Target string
+}
+
+// data format saved in the data plugin for an alias, under the same key of a service. The DNS plugin serves it as a
+// CNAME record, so a name is a service or an alias, never both
+type serviceAlias struct {
+	Alias string
+}
+
+// target of the alias saved with the name. isAlias is false for services and unknown names
+func (el *HttpServer) getAlias(name string) (target string, isAlias bool, err error) {
+	var found int
+	var dataFromDataSource []communsTypes.KeyValueType
+	var saved serviceAlias
+
+	err, found, dataFromDataSource = el.dataGet([]byte(el.servicePrefix + name))
+	if err != nil || found == 0 {
+		return "", false, err
+	}
+
+	// services are saved as a list and fail here
+	if json.Unmarshal(dataFromDataSource[0].V, &saved) != nil || saved.Alias == "" {
+		return "", false, nil
+	}
+
+	return saved.Alias, true, nil
+}
+
+// true if the alias name -> target makes a loop or a chain longer than kAliasMaxHops.
+// Targets ended in point are names outside of the API and end the chain
+func (el *HttpServer) aliasLoop(name, target string) (bool, error) {
+	var visited = map[string]bool{name: true}
+
+	for hop := 0; hop < kAliasMaxHops; hop++ {
+		if strings.HasSuffix(target, ".") {
+			return false, nil
+		}
+
+		if visited[target] {
+			return true, nil
+		}
+		visited[target] = true
+
+		next, isAlias, err := el.getAlias(target)
+		if err != nil || !isAlias {
+			return false, err
+		}
+
+		target = next
+	}
+
+	return true, nil
+}
+
+// http post/put method function
+// this method creates or changes the alias of a name. During a failover, one change of the alias points all clients
+// to the new service.
+//
+//   Raw JSon data format
+//   {
+//     "target": string. service name, ex.: "postgres-primary", or a name ended in point, ex.: "db.example.com."
+//   }
+//
+//   JSon output format:
+//   {
+//     "Meta": {
+//         "TotalCount": 1,
+//         "Success": true,
+//         "Error": ""
+//     },
+//     "Objects": [
+//         {
+//             "Alias": "postgres-primary"
+//         }
+//     ]
+//   }
+func (el *HttpServer) handlePutAlias(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var inData alias
+	var found int
+	var jsonData []byte
+	var output JSonOut
+	var dataToDataSource communsTypes.KeyValueType
+
+	if el.dataGet == nil || el.dataPut == nil {
+		output.ToOutput(0, errors.New("ben burkert dns plugin config error. please, define a getData and a putData function"), nil, w)
+		return
+	}
+
+	name := el.pathName(r)
+
+	jsonData, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	err = json.Unmarshal(jsonData, &inData)
+	if err != nil {
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, "unmarshal incoming json from client side error: "+err.Error()), nil, w)
+		return
+	}
+
+	if name == "" || inData.Target == "" || inData.Target == "." || inData.Target == name {
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, "alias data error. please, send a target different of the alias name"), nil, w)
+		return
+	}
+
+	_, isAlias, err := el.getAlias(name)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	if !isAlias {
+		err, found, _ = el.dataGet([]byte(el.servicePrefix + name))
+		if err != nil {
+			el.handleError(err)
+			output.ToOutput(0, errors.New("internal server error"), nil, w)
+			return
+		}
+
+		if found != 0 {
+			output.ToOutput(0, newStatusError(http.StatusConflict, "alias data error. "+name+" is a service with instances"), nil, w)
+			return
+		}
+	}
+
+	loop, err := el.aliasLoop(name, inData.Target)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	if loop {
+		output.ToOutput(0, newStatusError(http.StatusConflict, "alias data error. "+name+" -> "+inData.Target+" makes an alias loop"), nil, w)
+		return
+	}
+
+	saved := serviceAlias{Alias: inData.Target}
+
+	dataToDataSource.K = []byte(el.servicePrefix + name)
+	dataToDataSource.V, err = json.Marshal(&saved)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	err = el.dataPut(dataToDataSource)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	output.ToOutput(1, nil, []serviceAlias{saved}, w)
+}
+
+// http get method function
+// this method get the target of the alias
+func (el *HttpServer) handleGetAlias(w http.ResponseWriter, r *http.Request) {
+	var output JSonOut
+
+	if el.dataGet == nil {
+		output.ToOutput(0, errors.New("ben burkert dns plugin config error. please, define a getData function"), nil, w)
+		return
+	}
+
+	name := el.pathName(r)
+
+	target, isAlias, err := el.getAlias(name)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	if !isAlias {
+		output.ToOutput(0, newStatusError(http.StatusNotFound, "alias "+name+" not found"), nil, w)
+		return
+	}
+
+	output.ToOutput(1, nil, []serviceAlias{{Alias: target}}, w)
+}
+
+// http delete method function
+// this method delete the alias. Services with the same name are never deleted here
+func (el *HttpServer) handleDeleteAlias(w http.ResponseWriter, r *http.Request) {
+	var output JSonOut
+
+	if el.dataGet == nil || el.dataDelete == nil {
+		output.ToOutput(0, errors.New("ben burkert dns plugin config error. please, define a getData and a deleteData function"), nil, w)
+		return
+	}
+
+	name := el.pathName(r)
+
+	_, isAlias, err := el.getAlias(name)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	if !isAlias {
+		output.ToOutput(0, newStatusError(http.StatusNotFound, "alias "+name+" not found"), nil, w)
+		return
+	}
+
+	err = el.dataDelete([]byte(el.servicePrefix + name))
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	output.ToOutput(0, nil, nil, w)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// one line of the audit log
+type auditEntry struct {
+	Time     string
+	Identity string
+	Remote   string
+	Client   string `json:",omitempty"`
+	Method   string
+	Path     string
+	Status   int
+	Error    string `json:",omitempty"`
+}
+
+// auditLog writes each register, deregister and alias change as a json line. Without file, lines go to the plugin log
+type auditLog struct {
+	sync.Mutex
+	file  *os.File
+	onLog func(string)
+}
+
+func newAuditLog(path string, onLog func(string)) (*auditLog, error) {
+	var err error
+	var audit = &auditLog{onLog: onLog}
+
+	if path == "" {
+		return audit, nil
+	}
+
+	audit.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return audit, nil
+}
+
+func (el *auditLog) Write(entry auditEntry) {
+	if el == nil {
+		return
+	}
+
+	entry.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		return
+	}
+
+	if el.file == nil {
+		el.onLog("audit " + string(line))
+		return
+	}
+
+	el.Lock()
+	defer el.Unlock()
+
+	_, err = el.file.Write(append(line, '\n'))
+	if err != nil {
+		el.onLog("audit log error: " + err.Error() + " " + string(line))
+	}
+}
+
+// keeps the http status written by the handler, for the audit log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	// method, path and error of each name changed by the handler, ex.: the services of the batch. Without changes, the
+	// request is written as one entry
+	changes []auditEntry
+}
+
+func (el *statusRecorder) WriteHeader(status int) {
+	if el.status == 0 {
+		el.status = status
+	}
+	el.ResponseWriter.WriteHeader(status)
+}
+
+func (el *statusRecorder) Write(b []byte) (int, error) {
+	if el.status == 0 {
+		el.status = http.StatusOK
+	}
+	return el.ResponseWriter.Write(b)
+}
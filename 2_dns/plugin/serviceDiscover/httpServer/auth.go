@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// authentication configuration
+//
+//   policyFile: json file with the identities and the service names each one may change
+//   token:      token sent by SelfRegister() and Register(). It must belong to an identity of the policy file
+type authConfig struct {
+	PolicyFile string
+	Token      string
+}
+
+// policy file format
+//
+//   {
+//     "identities": [
+//       { "name": "deploy", "token": "c2VjcmV0LXRva2Vu", "services": ["node", "api-*"] },
+//       { "name": "payments.example.com", "services": ["payments*"] }
+//     ]
+//   }
+//
+//   Identities with token are found by the "Authorization: Bearer {token}" header. Identities without token are found
+//   by the common name or a DNS name of the client certificate, verified by the https listener.
+//   Services are patterns of path.Match, ex.: "*" allows every name.
+type authPolicy struct {
+	Identities []authIdentity
+}
+
+type authIdentity struct {
+	Name     string
+	Token    string
+	Services []string
+}
+
+var (
+	errAuthUnauthorized = errors.New("authentication required. please, send a token or a client certificate of the policy file")
+	errAuthForbidden    = errors.New("forbidden")
+)
+
+// checks who may register and deregister each service name
+type authorizer struct {
+	policy authPolicy
+	token  string
+}
+
+func newAuthorizer(config authConfig) (*authorizer, error) {
+	var auth = &authorizer{token: config.Token}
+
+	if config.PolicyFile == "" {
+		return nil, errors.New("auth policyFile must be a non empty string")
+	}
+
+	fileContent, err := ioutil.ReadFile(config.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(fileContent, &auth.policy)
+	if err != nil {
+		return nil, errors.New("auth policy file " + config.PolicyFile + ": " + err.Error())
+	}
+
+	for _, identity := range auth.policy.Identities {
+		if identity.Name == "" {
+			return nil, errors.New("auth policy identity name must be a non empty string")
+		}
+
+		for _, pattern := range identity.Services {
+			if _, err = path.Match(pattern, ""); err != nil {
+				return nil, errors.New("auth policy identity " + identity.Name + " service pattern " + pattern + ": " + err.Error())
+			}
+		}
+	}
+
+	return auth, nil
+}
+
+// identity of the request, by the token or by the client certificate. nil when the client isn't in the policy file
+func (el *authorizer) Identity(r *http.Request) *authIdentity {
+	var certificate *x509.Certificate
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) != 0 {
+		certificate = r.TLS.PeerCertificates[0]
+	}
+
+	return el.identity(strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")), certificate)
+}
+
+// identity of the token or, for identities without token, of the common name or a DNS name of the certificate.
+// Used by the http and the grpc api
+func (el *authorizer) identity(token string, certificate *x509.Certificate) *authIdentity {
+	var names []string
+
+	if certificate != nil {
+		names = append([]string{certificate.Subject.CommonName}, certificate.DNSNames...)
+	}
+
+	for k, identity := range el.policy.Identities {
+		if identity.Token != "" {
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(identity.Token)) == 1 {
+				return &el.policy.Identities[k]
+			}
+			continue
+		}
+
+		for _, name := range names {
+			if name != "" && name == identity.Name {
+				return &el.policy.Identities[k]
+			}
+		}
+	}
+
+	return nil
+}
+
+// identity allowed to change the service name. Returns errAuthUnauthorized for unknown clients and errAuthForbidden
+// for names out of the patterns of the identity
+func (el *authorizer) Authorize(r *http.Request, serviceName string) (*authIdentity, error) {
+	identity := el.Identity(r)
+	return identity, el.allowed(identity, serviceName)
+}
+
+func (el *authorizer) allowed(identity *authIdentity, serviceName string) error {
+	if identity == nil {
+		return errAuthUnauthorized
+	}
+
+	for _, pattern := range identity.Services {
+		if match, _ := path.Match(pattern, serviceName); match {
+			return nil
+		}
+	}
+
+	return errAuthForbidden
+}
+
+// token of the plugin, sent by SelfRegister() and Register()
+func (el *authorizer) Token() string {
+	if el == nil {
+		return ""
+	}
+
+	return el.token
+}
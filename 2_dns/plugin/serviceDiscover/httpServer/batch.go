@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/helmutkemper/communsTypesForGolangPlugin"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const kBatchMaxServices = 1000
+
+// data input format of the batch endpoint, one object for each service. Instances replace the instance set of the
+// service, no instances deregister it
+type batchItem struct {
+	Name      string
+	Instances []service
+}
+
+// records of the instance set of the service replaced by the instances. Instances are checked like in the register and
+// a blank target is the client address
+func (el *HttpServer) replaceRecords(r *http.Request, serviceName string, instances []service) ([]serviceRecord, error) {
+	var records = make([]serviceRecord, 0, len(instances))
+	var instanceList = make(map[string]bool)
+
+	_, isAlias, err := el.getAlias(serviceName)
+	if err != nil {
+		el.handleError(err)
+		return nil, errors.New("internal server error")
+	}
+
+	if isAlias {
+		return nil, newStatusError(http.StatusConflict, "register data error. "+serviceName+" is an alias")
+	}
+
+	for _, inData := range instances {
+		if (inData.Target == "" && inData.Port == 0) || inData.Target == "." {
+			return nil, newStatusError(http.StatusBadRequest, "register data error in "+serviceName+". please, don't send blank data")
+		}
+
+		err = inData.validateMetadata()
+		if err != nil {
+			return nil, newStatusError(http.StatusBadRequest, "register data error in "+serviceName+": "+err.Error())
+		}
+
+		if inData.Target == "" {
+			ip := el.clientIP(r)
+			if ip == nil {
+				return nil, newStatusError(http.StatusBadRequest, "register data error. client address not found, please, send the target")
+			}
+
+			inData.Target = ip.String() + "."
+		}
+
+		err = validateTarget(inData.Target)
+		if err != nil {
+			return nil, newStatusError(http.StatusBadRequest, "register data error in "+serviceName+": "+err.Error())
+		}
+
+		instance := inData.Target + ":" + strconv.Itoa(inData.Port)
+		if instanceList[instance] {
+			return nil, newStatusError(http.StatusBadRequest, "register data error. instance "+instance+" of the service "+serviceName+" is repeated")
+		}
+		instanceList[instance] = true
+
+		records = append(records, serviceRecord{Target: inData.Target, Port: inData.Port, Priority: 10, Weight: 10, Metadata: inData.Metadata, View: inData.View})
+	}
+
+	return records, nil
+}
+
+// http put method function, with a list in the body
+// this method replaces the instance set of the service in one write, so clients never see a part of the set. An empty
+// list deregisters the service.
+//
+//   Raw JSon data format
+//   [
+//     { "port": 8080, "target": "192.168.10.1." },
+//     { "port": 8080, "target": "192.168.10.2.", "metadata": {"version": "1.3.0"} }
+//   ]
+//
+//   JSon output format: the same of the register
+func (el *HttpServer) replaceService(w http.ResponseWriter, r *http.Request, serviceName string, jsonData []byte) {
+	var err error
+	var instances []service
+	var records []serviceRecord
+	var dataToSave []byte
+	var output JSonOut
+
+	err = json.Unmarshal(jsonData, &instances)
+	if err != nil {
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, "unmarshal incoming json from client side error: "+err.Error()), nil, w)
+		return
+	}
+
+	records, err = el.replaceRecords(r, serviceName, instances)
+	if err != nil {
+		output.ToOutput(0, err, nil, w)
+		return
+	}
+
+	if len(records) == 0 {
+		err = el.dataDelete([]byte(el.servicePrefix + serviceName))
+		if err != nil {
+			el.handleError(err)
+			output.ToOutput(0, errors.New("internal server error"), nil, w)
+			return
+		}
+
+		output.ToOutput(0, nil, records, w)
+		return
+	}
+
+	dataToSave, err = json.Marshal(&records)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	err = el.dataPut(communsTypes.KeyValueType{K: []byte(el.servicePrefix + serviceName), V: dataToSave})
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	output.ToOutput(len(records), nil, records, w)
+}
+
+// keys to put and to delete in the transaction of the batch. Each name is authorized on its own, when the auth section
+// is set, and one error fails the whole batch
+func (el *HttpServer) batchChanges(r *http.Request, items []batchItem) (put []communsTypes.KeyValueType, del [][]byte, result []serviceListJSonOut, err error) {
+	var records []serviceRecord
+	var dataToSave []byte
+	var nameList = make(map[string]bool)
+
+	for _, item := range items {
+		if item.Name == "" || strings.Contains(item.Name, "/") {
+			return nil, nil, nil, newStatusError(http.StatusBadRequest, "batch data error. service name must be a non empty string without '/'")
+		}
+
+		if nameList[item.Name] {
+			return nil, nil, nil, newStatusError(http.StatusBadRequest, "batch data error. service "+item.Name+" is repeated")
+		}
+		nameList[item.Name] = true
+
+		if el.auth != nil {
+			identity, authErr := el.auth.Authorize(r, item.Name)
+			if identity == nil {
+				return nil, nil, nil, newStatusError(http.StatusUnauthorized, errAuthUnauthorized.Error())
+			}
+
+			if authErr != nil {
+				return nil, nil, nil, newStatusError(http.StatusForbidden, "identity "+identity.Name+" may not change "+item.Name)
+			}
+		}
+
+		records, err = el.replaceRecords(r, item.Name, item.Instances)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		result = append(result, serviceListJSonOut{Name: item.Name, Instances: records})
+
+		if len(records) == 0 {
+			del = append(del, []byte(el.servicePrefix+item.Name))
+			continue
+		}
+
+		dataToSave, err = json.Marshal(&records)
+		if err != nil {
+			el.handleError(err)
+			return nil, nil, nil, errors.New("internal server error")
+		}
+
+		put = append(put, communsTypes.KeyValueType{K: []byte(el.servicePrefix + item.Name), V: dataToSave})
+	}
+
+	return put, del, result, nil
+}
+
+// each service of the batch is written to the audit log by handleMutation(), as a register or a deregister, with the
+// status of the batch
+func auditBatch(w http.ResponseWriter, items []batchItem, batchErr error) {
+	var change auditEntry
+
+	recorder, ok := w.(*statusRecorder)
+	if !ok {
+		return
+	}
+
+	if batchErr != nil {
+		change.Error = batchErr.Error()
+	}
+
+	for _, item := range items {
+		change.Method = http.MethodPut
+		if len(item.Instances) == 0 {
+			change.Method = http.MethodDelete
+		}
+		change.Path = "/service/" + item.Name
+
+		recorder.changes = append(recorder.changes, change)
+	}
+}
+
+// http post method function
+// this method registers and deregisters many services in one transaction of the data plugin. Every service is changed
+// or none is. The instances of each service replace its instance set, like PUT /service/{name} with a list, and an
+// empty list deregisters the service.
+//
+//   Raw JSon data format
+//   [
+//     { "name": "node", "instances": [{ "port": 8080, "target": "192.168.10.1." }, { "port": 8080, "target": "192.168.10.2." }] },
+//     { "name": "node-old", "instances": [] }
+//   ]
+//
+//   JSon output format:
+//   {
+//     "Meta": {
+//         "TotalCount": 2,
+//         "Success": true,
+//         "Error": ""
+//     },
+//     "Objects": [
+//         {
+//             "Name": "node",
+//             "Instances": [
+//                 { "Priority": 10, "Weight": 10, "Port": 8080, "Target": "192.168.10.1." },
+//                 { "Priority": 10, "Weight": 10, "Port": 8080, "Target": "192.168.10.2." }
+//             ]
+//         },
+//         {
+//             "Name": "node-old"
+//         }
+//     ]
+//   }
+func (el *HttpServer) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var jsonData []byte
+	var items []batchItem
+	var put []communsTypes.KeyValueType
+	var del [][]byte
+	var result []serviceListJSonOut
+	var output JSonOut
+
+	w.Header().Add("Content-Type", "application/json")
+
+	if el.dataGet == nil || el.dataTransaction == nil {
+		output.ToOutput(0, errors.New("ben burkert dns plugin config error. please, define a getData and a transactionData function"), nil, w)
+		return
+	}
+
+	jsonData, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		el.handleError(err)
+		output.ToOutput(0, errors.New("internal server error"), nil, w)
+		return
+	}
+
+	err = json.Unmarshal(jsonData, &items)
+	if err != nil {
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, "unmarshal incoming json from client side error: "+err.Error()), nil, w)
+		return
+	}
+
+	if len(items) == 0 || len(items) > kBatchMaxServices {
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, "batch data error. please, send from 1 to "+strconv.Itoa(kBatchMaxServices)+" services"), nil, w)
+		return
+	}
+
+	put, del, result, err = el.batchChanges(r, items)
+	if err == nil {
+		err = el.dataTransaction(put, del)
+		if err != nil {
+			el.handleError(err)
+			err = errors.New("internal server error")
+		}
+	}
+
+	auditBatch(w, items, err)
+
+	if err != nil {
+		if converted, ok := err.(*statusError); ok && converted.status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="service discover"`)
+		}
+
+		output.ToOutput(0, err, nil, w)
+		return
+	}
+
+	output.ToOutput(len(result), nil, result, w)
+}
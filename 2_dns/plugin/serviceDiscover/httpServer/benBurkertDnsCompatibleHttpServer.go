@@ -2,7 +2,17 @@
 JSon config format:
 {
   "port": 8080,
+  "address": "",
   "servicePrefix": "service.discover.",
+  "tls": {
+    "certFile": "/etc/dns/server.crt",
+    "keyFile": "/etc/dns/server.key",
+    "caFile": "/etc/dns/ca.crt",
+    "verifyClientCert": false
+  },
+  "grpc": {
+    "port": 8081
+  },
   "register": [
     {
       "schema": "http",
@@ -13,7 +23,26 @@ JSon config format:
 }
 
 url format:
-http[s]://{server}:{port}/service/{service_name}
+http[s]://{server}:{port}/service/{service_name}, https when the tls key is set
+
+The OpenAPI document of the api is served in [GET] localhost:8080/openapi.json and the Go client is the package
+plugin/serviceDiscover/httpClient
+
+With the grpc key, Register, Deregister, List and Watch are served by the gRPC service of
+plugin/serviceDiscover/registry/registry.proto too, with the same data plugin, auth policy and audit log
+
+http status:
+200 success
+400 invalid json or register data
+401 POST, PUT or DELETE without a token or client certificate of the policy file, when auth is set
+403 the identity of the token or client certificate may not change the name
+404 unknown path, service, instance or alias
+405 method not allowed in the path, the allowed methods are in the Allow header
+409 conflict between a service and an alias of the same name, or an alias loop
+500 internal error
+503 a check of the status endpoint failed
+
+Errors have the same JSon format, with "Success": false and the message in "Error"
 
 [POST] localhost:8080/service/node
 [PUT]  localhost:8080/service/node
@@ -21,7 +50,9 @@ http[s]://{server}:{port}/service/{service_name}
 Raw JSon data format to send
 {
   "port":     int,
-  "target":   string ended in point. ex.:"192.169.0.1." or "mongodb." [optional - when this value is omitted, there is the remote address of the client]
+  "target":   name or ip ended in point. ex.:"192.169.0.1.", "2001:db8::1." or "mongodb." [optional - when this value is omitted, there is the remote address of the client, IPv4 or IPv6]
+  "metadata": object of strings. ex.: {"version": "1.2.0", "zone": "a", "protocol": "grpc", "tags": "canary"} [optional - served as DNS-SD TXT record]
+  "view":     string. ex.: "internal" or "external" [optional - the DNS plugin serves the instance only to the clients of the view]
 }
 
 JSon return format
@@ -36,7 +67,55 @@ JSon return format
             "Priority": 10,
             "Weight": 10,
             "Port": 8080,
-            "Target": "192.168.10.1."
+            "Target": "192.168.10.1.",
+            "Metadata": {
+                "version": "1.2.0"
+            }
+        }
+    ]
+}
+
+[PUT]  localhost:8080/service/node
+
+Raw JSon list to replace the instance set of the service in one write. An empty list deregisters the service
+[
+  { "port": 8080, "target": "192.168.10.1." },
+  { "port": 8080, "target": "192.168.10.2.", "metadata": {"version": "1.3.0"} }
+]
+
+JSon return format: the same of the register, with the new instance set
+
+[POST] localhost:8080/batch
+
+Raw JSon data format to register and deregister many services in one transaction. Every service is changed or none
+is. The instances replace the instance set of each service, an empty list deregisters it. With auth, the identity must
+be allowed to change every name
+[
+  { "name": "node", "instances": [{ "port": 8080, "target": "192.168.10.1." }] },
+  { "name": "node-old", "instances": [] }
+]
+
+JSon return format, one object for each service, in the format of the list
+{
+    "Meta": {
+        "TotalCount": 2,
+        "Success": true,
+        "Error": ""
+    },
+    "Objects": [
+        {
+            "Name": "node",
+            "Instances": [
+                {
+                    "Priority": 10,
+                    "Weight": 10,
+                    "Port": 8080,
+                    "Target": "192.168.10.1."
+                }
+            ]
+        },
+        {
+            "Name": "node-old"
         }
     ]
 }
@@ -65,31 +144,130 @@ JSon return format
         }
     ]
 }
+[GET]  localhost:8080/service?prefix=node&target=192.168.10.1.&port=8080&offset=0&limit=100
+
+All query values are optional. Lists the services, sorted by name, with the instances of the target and port
+
+JSon return format, TotalCount is the number of services found before the offset and the limit
+{
+    "Meta": {
+        "TotalCount": 1,
+        "Success": true,
+        "Error": ""
+    },
+    "Objects": [
+        {
+            "Name": "node",
+            "Instances": [
+                {
+                    "Priority": 10,
+                    "Weight": 10,
+                    "Port": 8080,
+                    "Target": "192.168.10.1."
+                }
+            ]
+        }
+    ]
+}
+[GET]  localhost:8080/watch/node
+[GET]  localhost:8080/watch?prefix=node
+
+Server-sent events stream with the services, in the format of the list, and then each change
+event: put
+data: {"Name":"node","Instances":[{"Priority":10,"Weight":10,"Port":8080,"Target":"192.168.10.1."}]}
+
+event: delete
+data: {"Name":"node"}
+
+[POST] localhost:8080/alias/db
+[PUT]  localhost:8080/alias/db
+
+Raw JSon data format to send. db.tld. is served as CNAME of postgres-primary.tld.
+{
+  "target": string. service name, ex.: "postgres-primary", or a name ended in point, ex.: "db.example.com."
+}
+
+JSon return format
+{
+    "Meta": {
+        "TotalCount": 1,
+        "Success": true,
+        "Error": ""
+    },
+    "Objects": [
+        {
+            "Alias": "postgres-primary"
+        }
+    ]
+}
+
+[GET]    localhost:8080/alias/db
+[DELETE] localhost:8080/alias/db
+
+[GET]  localhost:8080/status
+
+JSon return format, with http status 200 when every check is nil or 503 otherwise. Counters set by the host, ex.: the
+responses dropped and slipped by the rate limit of the DNS plugin, are listed after the checks
+{
+    "Meta": {
+        "TotalCount": 3,
+        "Success": true,
+        "Error": ""
+    },
+    "Objects": [
+        {
+            "Name": "dns.live",
+            "Success": true,
+            "Error": ""
+        },
+        {
+            "Name": "dns.ready",
+            "Success": false,
+            "Error": "readiness probe didn't run yet"
+        },
+        {
+            "Name": "dns.counters",
+            "Success": true,
+            "Error": "",
+            "Counters": {
+                "rateLimit.queries": 1200,
+                "rateLimit.queriesDropped": 0,
+                "rateLimit.responsesAccepted": 1100,
+                "rateLimit.responsesDropped": 80,
+                "rateLimit.responsesSlipped": 20
+            }
+        }
+    ]
+}
 */
 package main
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"gRPC/2_dns/plugin/pluginConfig"
+	"gRPC/2_dns/plugin/serviceDiscover/httpClient"
 	"github.com/helmutkemper/communsTypesForGolangPlugin"
-	"github.com/helmutkemper/dns"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
-	"os"
-	"regexp"
+	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // used to organize the http server
 type handle struct {
 	Method string
 	Type   string
+	Name   bool
 	Func   func(w http.ResponseWriter, r *http.Request)
 }
 
@@ -98,8 +276,36 @@ type handleList []handle
 
 // data input format from endpoint name service
 type service struct {
-	Port   int
-	Target string
+	Port     int
+	Target   string
+	Metadata map[string]string
+	View     string
+}
+
+// data format saved in the data plugin, one object for each service instance. This format is compatible with the
+// json of dns.SRV
+type serviceRecord struct {
+	Priority int
+	Weight   int
+	Port     int
+	Target   string
+	Metadata map[string]string `json:",omitempty"`
+	View     string            `json:",omitempty"`
+}
+
+// check if the metadata can be served as DNS-SD TXT record. Each "key=value" must fit in a 255 bytes string
+func (el *service) validateMetadata() error {
+	for key, value := range el.Metadata {
+		if key == "" || strings.Contains(key, "=") {
+			return errors.New("metadata key must be a non empty string without '='")
+		}
+
+		if len(key)+len(value)+1 > 255 {
+			return errors.New("metadata " + key + " is too long. key=value must have up to 255 bytes")
+		}
+	}
+
+	return nil
 }
 
 // meta object compliant with http://json-schema.org/
@@ -109,6 +315,15 @@ type MetaJSonOut struct {
 	Error      string `json:"Error"`
 }
 
+// status of one check, or the counters of a plugin, of the status endpoint
+type statusJSonOut struct {
+	Name     string
+	Success  bool
+	Error    string
+	Counters map[string]uint64 `json:",omitempty"`
+}
+
+// service of the self register. Schema and endpoint are served as the "protocol" and "path" metadata of the instance
 type configJSonRegister struct {
 	Schema   string
 	Endpoint string
@@ -116,9 +331,16 @@ type configJSonRegister struct {
 }
 
 type configJSon struct {
-	Port          int
-	ServicePrefix string
-	Register      []configJSonRegister
+	Port           int
+	ServicePrefix  string
+	Register       []configJSonRegister
+	Auth           *authConfig
+	AuditLog       string
+	Address        string
+	Tls            *tlsConfig
+	TrustedProxies []string
+	Grpc           *grpcConfig
+	Advertise      *advertiseConfig
 }
 
 // output object compliant with http://json-schema.org/
@@ -132,7 +354,11 @@ func (el *JSonOut) ToOutput(totalCountAInt int, errorAErr error, dataATfc interf
 	w.Header().Set("Content-Type", "application/json;")
 
 	if errorAErr != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if converted, ok := errorAErr.(*statusError); ok {
+			status = converted.status
+		}
+		w.WriteHeader(status)
 
 		el.Meta = MetaJSonOut{
 			Error:      fmt.Sprint(errorAErr),
@@ -172,43 +398,94 @@ type PluginHttpServerInterface interface {
 	SetDataGet(v func([]byte, []communsTypes.KeyValueType) (error, int))
 	SetDataPut(v func(communsTypes.KeyValueType) error)
 	SetDataDelete(v func([]byte) error)
+	SetDataGetByPrefix(v func([]byte) (error, int, []communsTypes.KeyValueType))
+	SetDataTransaction(v func([]communsTypes.KeyValueType, [][]byte) error)
 	SelfRegister() error
 	Register(name, target string, port int) error
 	GetServiceKeyPrefix() string
+	SetStatus(name string, v func() error)
+	SetCounters(name string, v func() map[string]uint64)
+	Changed(events []communsTypes.KeyValueType)
 }
 
 // plugin main struct
 type HttpServer struct {
-	handleList    handleList
-	port          int
-	servicePrefix string
-	dataGet       func([]byte) (error, int, []communsTypes.KeyValueType)
-	dataPut       func(communsTypes.KeyValueType) error
-	dataDelete    func([]byte) error
-	register      []configJSonRegister
+	handleList      handleList
+	port            int
+	servicePrefix   string
+	dataGet         func([]byte) (error, int, []communsTypes.KeyValueType)
+	dataPut         func(communsTypes.KeyValueType) error
+	dataDelete      func([]byte) error
+	dataGetByPrefix func([]byte) (error, int, []communsTypes.KeyValueType)
+	dataTransaction func([]communsTypes.KeyValueType, [][]byte) error
+	register        []configJSonRegister
+	status          map[string]func() error
+	counters        map[string]func() map[string]uint64
+	auth            *authorizer
+	audit           *auditLog
+	address         string
+	tlsServer       *tls.Config
+	client          *http.Client
+	watch           *watchHub
+	trustedProxies  trustedProxies
+	grpcPort        int
+	advertise       advertiseConfig
+
+	// status and counters are set by the host on each reload, while the status endpoint reads them
+	statusMutex sync.RWMutex
 }
 
 // plugin on load function
 // this is a first function to run after plugin loaded
 // conf[0] - string containing a json file path of configuration file
+// conf[1] - optional config object, replaced by the keys of the file and by the environment variables
 //
 //   json file example:
 //   {
-//     "addressAndPort": ":8080",
-//     "servicePrefix": "service"
+//     "port": 8080,
+//     "address": "10.0.0.1",
+//     "servicePrefix": "service",
+//     "tls": {
+//       "certFile": "/etc/dns/server.crt",
+//       "keyFile": "/etc/dns/server.key",
+//       "caFile": "/etc/dns/ca.crt",
+//       "verifyClientCert": false,
+//       "serverName": "dns.example.com"
+//     },
+//     "auth": {
+//       "policyFile": "/etc/dns/policy.json",
+//       "token": "c2VsZi1yZWdpc3Rlci10b2tlbg"
+//     },
+//     "auditLog": "/var/log/dns/audit.log",
+//     "trustedProxies": ["10.0.0.0/8", "fd00::/8"],
+//     "grpc": {
+//       "port": 8081
+//     },
+//     "advertise": {
+//       "address": "dns.example.com"
+//     }
 //   }
+//
+//   Each key can be set by an environment variable with the REST prefix, see pluginConfig, ex.: REST_PORT,
+//   REST_TLS_CERT_FILE, REST_TRUSTED_PROXIES=10.0.0.0/8,fd00::/8 or
+//   REST_REGISTER='[{"schema": "http", "endpoint": "service", "name": "http.service.discover"}]'.
+//   advertise is optional. The name or ip published as SRV target by SelfRegister(), see advertiseConfig.
+//   grpc is optional. The registry is served as the grpc service of plugin/serviceDiscover/registry too, see
+//   grpcConfig.
+//   trustedProxies is optional. The client address of requests from these networks is read from the Forwarded or
+//   X-Forwarded-For headers, used as target when the register data has no target.
+//   address is optional. The listener is bound to it, or to all interfaces when it is blank.
+//   tls is optional. The listener serves https with the certificate, see tlsConfig. SelfRegister() and Register()
+//   use https and trust caFile.
+//   auth is optional. Without it, anyone can register and deregister services. With it, POST, PUT and DELETE need
+//   an identity of the policy file allowed to change the name, see authPolicy.
+//   auditLog is optional. Every register, deregister and alias change is written to it, or to the plugin log.
 func (el *HttpServer) OnLoad(conf ...interface{}) error {
 	var err error
 	var fileContent []byte
 	var jsonData configJSon
-	var filePath = conf[0].([]interface{})[0].(string)
 
-	if _, err = os.Stat(filePath); os.IsNotExist(err) {
-		el.handleError(err)
-		return err
-	}
-
-	fileContent, err = ioutil.ReadFile(filePath)
+	fileContent, err = pluginConfig.Load("REST", conf[0].([]interface{}), configJSon{})
 	if err != nil {
 		el.handleError(err)
 		return err
@@ -240,105 +517,194 @@ func (el *HttpServer) OnLoad(conf ...interface{}) error {
 		return err
 	}
 
-	return nil
-}
+	el.auth = nil
+	if jsonData.Auth != nil {
+		el.auth, err = newAuthorizer(*jsonData.Auth)
+		if err != nil {
+			el.handleError(err)
+			return err
+		}
+	}
 
-// set this http server functions on DNS registers by self http interface
-func (el *HttpServer) SelfRegister() error {
-	var selfAddress string
-	var err error
-	selfAddress, err = el.externalIP()
+	el.audit, err = newAuditLog(jsonData.AuditLog, el.log)
 	if err != nil {
 		el.handleError(err)
 		return err
 	}
 
-	for _, register := range el.register {
-		url := register.Schema + "://" + selfAddress + ":" + strconv.Itoa(el.port) + "/" + register.Endpoint + "/" + register.Name
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(`{ "port": `+strconv.Itoa(el.port)+`, "target": "`+register.Schema+"://"+selfAddress+":"+strconv.Itoa(el.port)+"/"+register.Endpoint+"/"+`." }`)))
+	el.watch = &watchHub{}
+
+	el.trustedProxies, err = newTrustedProxies(jsonData.TrustedProxies)
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
+
+	el.address = jsonData.Address
+
+	el.tlsServer = nil
+	el.client = newHttpClient(nil)
+	if jsonData.Tls != nil {
+		var tlsClient *tls.Config
+
+		el.tlsServer, tlsClient, err = newTlsConfig(*jsonData.Tls)
 		if err != nil {
 			el.handleError(err)
 			return err
 		}
 
-		req.Header.Set("Content-Type", "application/json")
+		el.client = newHttpClient(tlsClient)
+	}
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+	el.advertise = advertiseConfig{}
+	if jsonData.Advertise != nil {
+		err = jsonData.Advertise.check()
 		if err != nil {
 			el.handleError(err)
 			return err
 		}
 
-		body, _ := ioutil.ReadAll(resp.Body)
-		err = resp.Body.Close()
-		if err != nil {
+		el.advertise = *jsonData.Advertise
+	}
+
+	el.grpcPort = 0
+	if jsonData.Grpc != nil {
+		if jsonData.Grpc.Port <= 0 || jsonData.Grpc.Port > 65535 || jsonData.Grpc.Port == el.port {
+			err = errors.New("json grpc port must be between 1 and 65535 and differ from the http port")
 			el.handleError(err)
 			return err
 		}
 
-		el.log(fmt.Sprintf("self register url %v\n", url))
-		el.log(fmt.Sprintf("self register response %s\n", body))
+		el.grpcPort = jsonData.Grpc.Port
 	}
 
 	return nil
 }
 
-// allows the registration of a new service by the http interface
-func (el *HttpServer) Register(name, target string, port int) error {
+// set this http server functions on DNS registers by self http interface. The SRV target is the advertise address,
+// see advertiseConfig. Returns the error to the host, that retries while the listener starts
+func (el *HttpServer) SelfRegister() error {
 	var selfAddress string
+	var target string
 	var err error
-	selfAddress, err = el.externalIP()
+	var instances []httpClient.Instance
+
+	selfAddress, err = el.requestAddress()
 	if err != nil {
 		el.handleError(err)
 		return err
 	}
 
-	for _, register := range el.register {
-		url := register.Schema + "://" + selfAddress + ":" + strconv.Itoa(el.port) + "/" + register.Endpoint + "/" + name
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(`{ "port": `+strconv.Itoa(port)+`, "target": "`+target+`" }`)))
-		if err != nil {
-			el.handleError(err)
-			return err
-		}
+	target, err = el.advertiseTarget()
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
 
-		req.Header.Set("Content-Type", "application/json")
+	client := el.newClient(selfAddress)
+	for _, register := range el.register {
+		var metadata = make(map[string]string)
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			el.handleError(err)
-			return err
+		// the SRV record has the host and the port only, the schema and the endpoint are served as DNS-SD TXT record
+		if register.Schema != "" {
+			metadata["protocol"] = register.Schema
+		}
+		if register.Endpoint != "" {
+			metadata["path"] = "/" + strings.TrimPrefix(register.Endpoint, "/")
 		}
 
-		body, _ := ioutil.ReadAll(resp.Body)
-		err = resp.Body.Close()
+		instances, err = client.Register(register.Name, httpClient.RegisterData{Port: el.port, Target: target, Metadata: metadata})
 		if err != nil {
 			el.handleError(err)
 			return err
 		}
 
-		el.log(fmt.Sprintf("self register url %v\n", url))
-		el.log(fmt.Sprintf("self register response %s\n", body))
+		el.log(fmt.Sprintf("self register %v: %v\n", register.Name, instances))
 	}
 
 	return nil
 }
 
+// allows the registration of a new service by the http interface
+func (el *HttpServer) Register(name, target string, port int) error {
+	var selfAddress string
+	var err error
+	var instances []httpClient.Instance
+
+	selfAddress, err = el.requestAddress()
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
+
+	instances, err = el.newClient(selfAddress).Register(name, httpClient.RegisterData{Port: port, Target: target})
+	if err != nil {
+		el.handleError(err)
+		return err
+	}
+
+	el.log(fmt.Sprintf("register %v: %v\n", name, instances))
+
+	return nil
+}
+
+// client of the api used by SelfRegister() and Register(), with the tls configuration and the token of the plugin
+func (el *HttpServer) newClient(selfAddress string) *httpClient.Client {
+	client := httpClient.New(el.scheme()+"://"+net.JoinHostPort(selfAddress, strconv.Itoa(el.port)), el.client)
+	client.SetToken(el.auth.Token())
+
+	return client
+}
+
 // plugin connect function
 func (el *HttpServer) Connect() error {
+	el.handleList = el.routes()
+
 	server := http.NewServeMux()
 	server.HandleFunc("/", el.handleFunc)
 
 	newServer := &http.Server{
-		Addr:    ":" + strconv.Itoa(el.port),
-		Handler: server,
+		Addr:      net.JoinHostPort(el.address, strconv.Itoa(el.port)),
+		Handler:   server,
+		TLSConfig: el.tlsServer,
+	}
+
+	if el.grpcPort != 0 {
+		if err := el.listenGrpc(); err != nil {
+			el.handleError(err)
+			return err
+		}
+	}
+
+	if el.tlsServer != nil {
+		// the certificate is in TLSConfig
+		return newServer.ListenAndServeTLS("", "")
 	}
 
 	return newServer.ListenAndServe()
 }
 
-func (el HttpServer) GetServiceKeyPrefix() string {
+// scheme of the listener
+func (el *HttpServer) scheme() string {
+	if el.tlsServer != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// address used by SelfRegister() and Register() to reach the listener: the bind address or, when the listener is
+// bound to all interfaces, the external ip
+func (el *HttpServer) requestAddress() (string, error) {
+	ip := net.ParseIP(el.address)
+	if el.address != "" && (ip == nil || !ip.IsUnspecified()) {
+		return el.address, nil
+	}
+
+	return el.externalIP()
+}
+
+func (el *HttpServer) GetServiceKeyPrefix() string {
 	return el.servicePrefix
 }
 
@@ -357,6 +723,40 @@ func (el *HttpServer) SetDataDelete(v func([]byte) error) {
 	el.dataDelete = v
 }
 
+// plugin set dataGetByPrefix from external plugin data function
+func (el *HttpServer) SetDataGetByPrefix(v func([]byte) (error, int, []communsTypes.KeyValueType)) {
+	el.dataGetByPrefix = v
+}
+
+// plugin set dataTransaction from external plugin data function, used by the batch endpoint
+func (el *HttpServer) SetDataTransaction(v func([]communsTypes.KeyValueType, [][]byte) error) {
+	el.dataTransaction = v
+}
+
+// plugin set a check served by the status endpoint, ex.: "dns.ready". The check returns nil when everything is fine
+func (el *HttpServer) SetStatus(name string, v func() error) {
+	el.statusMutex.Lock()
+	defer el.statusMutex.Unlock()
+
+	if el.status == nil {
+		el.status = make(map[string]func() error)
+	}
+
+	el.status[name] = v
+}
+
+// plugin set counters served by the status endpoint, ex.: "dns.counters"
+func (el *HttpServer) SetCounters(name string, v func() map[string]uint64) {
+	el.statusMutex.Lock()
+	defer el.statusMutex.Unlock()
+
+	if el.counters == nil {
+		el.counters = make(map[string]func() map[string]uint64)
+	}
+
+	el.counters[name] = v
+}
+
 // shown critical erros in log with file and line numbers
 func (el *HttpServer) handleError(err error) {
 	if err != nil {
@@ -370,86 +770,45 @@ func (el *HttpServer) log(info string) {
 	log.Printf("[Ben Burkert DNS compatible http server plugin log] %v", info)
 }
 
-// http handle function
-func (el *HttpServer) handleFunc(w http.ResponseWriter, r *http.Request) {
-	el.handleList = []handle{
-		{
-			Method: http.MethodPost,
-			Type:   "service",
-			Func:   el.handlePutService,
-		},
-		{
-			Method: http.MethodPut,
-			Type:   "service",
-			Func:   el.handlePutService,
-		},
-		{
-			Method: http.MethodGet,
-			Type:   "service",
-			Func:   el.handleGetService,
-		},
-		{
-			Method: http.MethodDelete,
-			Type:   "service",
-			Func:   el.handleDeleteService,
-		},
-	}
-
-	urlElements := strings.Split(r.Method+r.URL.Path, "/")
-
-	method := urlElements[0]
-	endpointType := urlElements[1]
-
-	for _, handleData := range el.handleList {
-
-		if handleData.Method == method && handleData.Type == endpointType {
-			handleData.Func(w, r)
-		}
-	}
-}
-
 // http delete method function
 // this method delete the DNS record that has the same port and the same target contained in the original list of
 // records from service name.
 // If there are no more records in the list record the whole service is deleted from the database.
 //
-//  Raw JSon data format
-//  {
-//    "port":     int,
-//    "target":   string ended in point. ex.:"192.169.0.1." or "mongodb."
-//  }
+//   Raw JSon data format
+//   {
+//     "port":     int,
+//     "target":   string ended in point. ex.:"192.169.0.1." or "mongodb."
+//   }
 //
-//  JSon output format:
-//  {
-//    "Meta": {
-//        "TotalCount": 2,
-//        "Success": true,
-//        "Error": ""
-//    },
-//    "Objects": [
-//        {
-//            "Priority": 10,
-//            "Weight": 10,
-//            "Port": 8080,
-//            "Target": "192.168.10.1."
-//        },
-//        {
-//            "Priority": 10,
-//            "Weight": 10,
-//            "Port": 8080,
-//            "Target": "192.168.10.2."
-//        }
-//    ]
-//  }
+//   JSon output format:
+//   {
+//     "Meta": {
+//         "TotalCount": 2,
+//         "Success": true,
+//         "Error": ""
+//     },
+//     "Objects": [
+//         {
+//             "Priority": 10,
+//             "Weight": 10,
+//             "Port": 8080,
+//             "Target": "192.168.10.1."
+//         },
+//         {
+//             "Priority": 10,
+//             "Weight": 10,
+//             "Port": 8080,
+//             "Target": "192.168.10.2."
+//         }
+//     ]
+//   }
 func (el *HttpServer) handleDeleteService(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var inData service
-	var records []dns.SRV
+	var records []serviceRecord
 	var jsonData []byte
-	var dataToSave communsTypes.KeyValueType
 	var output JSonOut
-	var found int
-	var dataFromDataSource []communsTypes.KeyValueType
 
 	w.Header().Add("Content-Type", "application/json")
 
@@ -463,12 +822,10 @@ func (el *HttpServer) handleDeleteService(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	urlElements := strings.Split(r.Method+r.URL.Path, "/")
-	serviceName := urlElements[2]
+	serviceName := el.pathName(r)
 
 	jsonData, err = ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(503)
 		el.handleError(err)
 		output.ToOutput(0, errors.New("internal server error"), nil, w)
 		return
@@ -476,63 +833,74 @@ func (el *HttpServer) handleDeleteService(w http.ResponseWriter, r *http.Request
 
 	err = json.Unmarshal(jsonData, &inData)
 	if err != nil {
-		w.WriteHeader(503)
-		output.ToOutput(0, errors.New("unmarshal incoming json from client side error: "+err.Error()), nil, w)
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, "unmarshal incoming json from client side error: "+err.Error()), nil, w)
 		return
 	}
 
+	records, err = el.deregisterInstance(serviceName, inData)
+	if err != nil {
+		output.ToOutput(0, err, nil, w)
+		return
+	}
+
+	output.ToOutput(len(records), nil, records, w)
+}
+
+// deletes the instance of the same port and target from the service and returns the instances left. The service is
+// deleted with its last instance. Used by the http and the grpc api
+func (el *HttpServer) deregisterInstance(serviceName string, inData service) ([]serviceRecord, error) {
+	var err error
+	var records []serviceRecord
+	var dataToSave communsTypes.KeyValueType
+	var found int
+	var deleted bool
+	var dataFromDataSource []communsTypes.KeyValueType
+
 	err, found, dataFromDataSource = el.dataGet([]byte(el.servicePrefix + serviceName))
 	if err != nil {
-		w.WriteHeader(503)
 		el.handleError(err)
-		output.ToOutput(0, errors.New("internal server error"), nil, w)
-		return
+		return nil, errors.New("internal server error")
 	}
 
 	if found == 0 {
-		output.ToOutput(0, nil, nil, w)
-		return
+		return nil, newStatusError(http.StatusNotFound, "service "+serviceName+" not found")
+	}
+
+	if _, isAlias, _ := el.getAlias(serviceName); isAlias {
+		return nil, newStatusError(http.StatusConflict, serviceName+" is an alias. please, use the alias endpoint")
 	}
 
 	for dataSourceKey := range dataFromDataSource {
 		err = json.Unmarshal(dataFromDataSource[dataSourceKey].V, &records)
 		if err != nil {
-			w.WriteHeader(503)
 			el.handleError(err)
-			output.ToOutput(0, errors.New("internal server error"), nil, w)
-			return
+			return nil, errors.New("internal server error")
 		}
 
 		for k, record := range records {
-			rTest := record.Get()
-			if rTest.(*dns.SRV).Port == inData.Port && rTest.(*dns.SRV).Target == inData.Target {
+			if record.Port == inData.Port && record.Target == inData.Target {
+				deleted = true
 
 				records = append(records[:k], records[k+1:]...)
 
 				dataToSave.V, err = json.Marshal(&records)
 				dataToSave.K = []byte(el.servicePrefix + serviceName)
 				if err != nil {
-					w.WriteHeader(503)
 					el.handleError(err)
-					output.ToOutput(0, errors.New("internal server error"), nil, w)
-					return
+					return nil, errors.New("internal server error")
 				}
 
 				if len(records) == 0 {
 					err = el.dataDelete([]byte(el.servicePrefix + serviceName))
 					if err != nil {
-						w.WriteHeader(503)
 						el.handleError(err)
-						output.ToOutput(0, errors.New("internal server error"), nil, w)
-						return
+						return nil, errors.New("internal server error")
 					}
 				} else {
 					err = el.dataPut(dataToSave)
 					if err != nil {
-						w.WriteHeader(503)
 						el.handleError(err)
-						output.ToOutput(0, errors.New("internal server error"), nil, w)
-						return
+						return nil, errors.New("internal server error")
 					}
 				}
 				break
@@ -540,37 +908,41 @@ func (el *HttpServer) handleDeleteService(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	output.ToOutput(len(records), nil, records, w)
+	if !deleted {
+		return nil, newStatusError(http.StatusNotFound, "instance "+inData.Target+" port "+strconv.Itoa(inData.Port)+" of the service "+serviceName+" not found")
+	}
+
+	return records, nil
 }
 
 // http get method function
 // this method get the DNS record list from service name
 //
-//  JSon output format:
-//  {
-//    "Meta": {
-//        "TotalCount": 2,
-//        "Success": true,
-//        "Error": ""
-//    },
-//    "Objects": [
-//        {
-//            "Priority": 10,
-//            "Weight": 10,
-//            "Port": 8080,
-//            "Target": "192.168.10.1."
-//        },
-//        {
-//            "Priority": 10,
-//            "Weight": 10,
-//            "Port": 8080,
-//            "Target": "192.168.10.2."
-//        }
-//    ]
-//  }
+//   JSon output format:
+//   {
+//     "Meta": {
+//         "TotalCount": 2,
+//         "Success": true,
+//         "Error": ""
+//     },
+//     "Objects": [
+//         {
+//             "Priority": 10,
+//             "Weight": 10,
+//             "Port": 8080,
+//             "Target": "192.168.10.1."
+//         },
+//         {
+//             "Priority": 10,
+//             "Weight": 10,
+//             "Port": 8080,
+//             "Target": "192.168.10.2."
+//         }
+//     ]
+//   }
 func (el *HttpServer) handleGetService(w http.ResponseWriter, r *http.Request) {
 	var err error
-	var records []dns.SRV
+	var records []serviceRecord
 	//var recordsAsJSonString string
 	var output JSonOut
 	var found int
@@ -588,20 +960,22 @@ func (el *HttpServer) handleGetService(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	urlElements := strings.Split(r.Method+r.URL.Path, "/")
-
-	serviceName := urlElements[2]
+	serviceName := el.pathName(r)
 
 	err, found, dataFromDataSource = el.dataGet([]byte(el.servicePrefix + serviceName))
 	if err != nil {
-		w.WriteHeader(503)
 		el.handleError(err)
 		output.ToOutput(0, errors.New("internal server error"), nil, w)
 		return
 	}
 
 	if found == 0 {
-		output.ToOutput(0, nil, nil, w)
+		output.ToOutput(0, newStatusError(http.StatusNotFound, "service "+serviceName+" not found"), nil, w)
+		return
+	}
+
+	if _, isAlias, _ := el.getAlias(serviceName); isAlias {
+		output.ToOutput(0, newStatusError(http.StatusConflict, serviceName+" is an alias. please, use the alias endpoint"), nil, w)
 		return
 	}
 
@@ -611,46 +985,44 @@ func (el *HttpServer) handleGetService(w http.ResponseWriter, r *http.Request) {
 }
 
 // http post/put method function
-// this method creates a new DNS record list ou append a new record in list.
+// this method creates a new DNS record list ou append a new record in list. PUT with a list replaces the list, see
+// replaceService().
 //
-//  Raw JSon data format
-//  {
-//    "port":     int,
-//    "target":   string ended in point. ex.:"192.169.0.1." or "mongodb."
-//  }
+//   Raw JSon data format
+//   {
+//     "port":     int,
+//     "target":   string ended in point. ex.:"192.169.0.1." or "mongodb."
+//     "view":     string. ex.: "internal" [optional]
+//   }
 //
-//  JSon output format:
-//  {
-//    "Meta": {
-//        "TotalCount": 2,
-//        "Success": true,
-//        "Error": ""
-//    },
-//    "Objects": [
-//        {
-//            "Priority": 10,
-//            "Weight": 10,
-//            "Port": 8080,
-//            "Target": "192.168.10.1."
-//        },
-//        {
-//            "Priority": 10,
-//            "Weight": 10,
-//            "Port": 8080,
-//            "Target": "192.168.10.2."
-//        }
-//    ]
-//  }
+//   JSon output format:
+//   {
+//     "Meta": {
+//         "TotalCount": 2,
+//         "Success": true,
+//         "Error": ""
+//     },
+//     "Objects": [
+//         {
+//             "Priority": 10,
+//             "Weight": 10,
+//             "Port": 8080,
+//             "Target": "192.168.10.1."
+//         },
+//         {
+//             "Priority": 10,
+//             "Weight": 10,
+//             "Port": 8080,
+//             "Target": "192.168.10.2."
+//         }
+//     ]
+//   }
 func (el *HttpServer) handlePutService(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var inData service
-	var records []dns.SRV
-	var found int
+	var records []serviceRecord
 	var jsonData []byte
-	var dataToSave []byte
 	var output JSonOut
-	var re *regexp.Regexp
-	var dataFromDataSource []communsTypes.KeyValueType
 
 	w.Header().Add("Content-Type", "application/json")
 
@@ -664,68 +1036,86 @@ func (el *HttpServer) handlePutService(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	urlElements := strings.Split(r.Method+r.URL.Path, "/")
-	serviceName := urlElements[2]
+	serviceName := el.pathName(r)
 
 	jsonData, err = ioutil.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(503)
 		el.handleError(err)
 		output.ToOutput(0, errors.New("internal server error"), nil, w)
 		return
 	}
 
+	// a list replaces the instance set
+	if r.Method == http.MethodPut && bytes.HasPrefix(bytes.TrimSpace(jsonData), []byte("[")) {
+		el.replaceService(w, r, serviceName, jsonData)
+		return
+	}
+
 	err = json.Unmarshal(jsonData, &inData)
 	if err != nil {
-		w.WriteHeader(503)
-		output.ToOutput(0, errors.New("unmarshal incoming json from client side error: "+err.Error()), nil, w)
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, "unmarshal incoming json from client side error: "+err.Error()), nil, w)
 		return
 	}
 
+	records, err = el.registerInstance(serviceName, inData, el.clientIP(r))
+	if err != nil {
+		output.ToOutput(0, err, nil, w)
+		return
+	}
+
+	output.ToOutput(len(records), nil, records, w)
+}
+
+// appends the instance to the service, or updates the metadata and the view of the instance, and returns the
+// instances of the service. A blank target is the address of the client. Used by the http and the grpc api
+func (el *HttpServer) registerInstance(serviceName string, inData service, clientIP net.IP) ([]serviceRecord, error) {
+	var err error
+	var records []serviceRecord
+	var found int
+	var dataToSave []byte
+	var dataFromDataSource []communsTypes.KeyValueType
+
 	err, found, dataFromDataSource = el.dataGet([]byte(el.servicePrefix + serviceName))
 	if err != nil {
-		w.WriteHeader(503)
 		el.handleError(err)
-		output.ToOutput(0, errors.New("internal server error"), nil, w)
-		return
+		return nil, errors.New("internal server error")
+	}
+
+	if _, isAlias, _ := el.getAlias(serviceName); isAlias {
+		return nil, newStatusError(http.StatusConflict, "register data error. "+serviceName+" is an alias")
 	}
 
 	if (inData.Target == "" && inData.Port == 0) || inData.Target == "." {
-		w.WriteHeader(503)
-		el.handleError(err)
-		output.ToOutput(0, errors.New("register data error. please, don't send blank data"), nil, w)
-		return
+		return nil, newStatusError(http.StatusBadRequest, "register data error. please, don't send blank data")
 	}
 
-	if inData.Target == "" && inData.Port != 0 {
-		addr := r.RemoteAddr
-		if strings.HasPrefix(addr, "[::1]") {
-			addr = "127.0.0.1"
-		} else {
-			re, err = regexp.Compile("^([0-9]{1,3}.[0-9]{1,3}.[0-9]{1,3}.[0-9]{1,3})(:.*)$")
-			if err != nil {
-				w.WriteHeader(503)
-				el.handleError(err)
-				output.ToOutput(0, errors.New("internal server error"), nil, w)
-				return
-			}
-			addr = string(re.ReplaceAll([]byte(addr), []byte("$1")))
+	err = inData.validateMetadata()
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, "register data error: "+err.Error())
+	}
 
+	if inData.Target == "" && inData.Port != 0 {
+		if clientIP == nil {
+			return nil, newStatusError(http.StatusBadRequest, "register data error. client address not found, please, send the target")
 		}
-		inData.Target = addr + "."
+
+		inData.Target = clientIP.String() + "."
+	}
+
+	err = validateTarget(inData.Target)
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, "register data error: "+err.Error())
 	}
 
 	if found == 0 {
-		records = []dns.SRV{
-			{Target: inData.Target, Port: inData.Port, Priority: 10, Weight: 10},
+		records = []serviceRecord{
+			{Target: inData.Target, Port: inData.Port, Priority: 10, Weight: 10, Metadata: inData.Metadata, View: inData.View},
 		}
 
 		dataToSave, err = json.Marshal(&records)
 		if err != nil {
-			w.WriteHeader(503)
 			el.handleError(err)
-			output.ToOutput(0, errors.New("internal server error"), nil, w)
-			return
+			return nil, errors.New("internal server error")
 		}
 
 		dataToDataSource := communsTypes.KeyValueType{}
@@ -733,38 +1123,46 @@ func (el *HttpServer) handlePutService(w http.ResponseWriter, r *http.Request) {
 		dataToDataSource.V = dataToSave
 		err = el.dataPut(dataToDataSource)
 		if err != nil {
-			w.WriteHeader(503)
 			el.handleError(err)
-			output.ToOutput(0, errors.New("internal server error"), nil, w)
-			return
+			return nil, errors.New("internal server error")
 		}
 	} else {
 
 		err = json.Unmarshal(dataFromDataSource[0].V, &records)
 		if err != nil {
-			w.WriteHeader(503)
 			el.handleError(err)
-			output.ToOutput(0, errors.New("internal server error"), nil, w)
-			return
+			return nil, errors.New("internal server error")
 		}
 
 		pass := true
-		for _, record := range records {
-			rTest := record.Get()
-			if rTest.(*dns.SRV).Port == inData.Port && rTest.(*dns.SRV).Target == inData.Target {
+		update := false
+		for k, record := range records {
+			if record.Port == inData.Port && record.Target == inData.Target {
 				pass = false
+
+				// a new register of the same instance updates its metadata
+				if inData.Metadata != nil && !reflect.DeepEqual(record.Metadata, inData.Metadata) {
+					records[k].Metadata = inData.Metadata
+					update = true
+				}
+
+				// and its view
+				if record.View != inData.View {
+					records[k].View = inData.View
+					update = true
+				}
 			}
 		}
 
 		if pass == true {
-			records = append(records, dns.SRV{Target: inData.Target, Port: inData.Port, Priority: 10, Weight: 10})
+			records = append(records, serviceRecord{Target: inData.Target, Port: inData.Port, Priority: 10, Weight: 10, Metadata: inData.Metadata, View: inData.View})
+		}
 
+		if pass == true || update == true {
 			dataToSave, err = json.Marshal(&records)
 			if err != nil {
-				w.WriteHeader(503)
 				el.handleError(err)
-				output.ToOutput(0, errors.New("internal server error"), nil, w)
-				return
+				return nil, errors.New("internal server error")
 			}
 
 			dataToDataSource := communsTypes.KeyValueType{}
@@ -772,22 +1170,87 @@ func (el *HttpServer) handlePutService(w http.ResponseWriter, r *http.Request) {
 			dataToDataSource.V = dataToSave
 			err = el.dataPut(dataToDataSource)
 			if err != nil {
-				w.WriteHeader(503)
 				el.handleError(err)
-				output.ToOutput(0, errors.New("internal server error"), nil, w)
-				return
+				return nil, errors.New("internal server error")
 			}
 		}
 	}
 
-	output.ToOutput(len(records), nil, records, w)
+	return records, nil
 }
 
+// http get method function
+// this method runs the checks set by the host, like the readiness probe of the DNS plugin
+func (el *HttpServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	var output JSonOut
+	var nameList []string
+	var statusList []statusJSonOut
+	var httpStatus = http.StatusOK
+	var checks = make(map[string]func() error)
+	var counters = make(map[string]func() map[string]uint64)
+
+	// the functions run without the lock, so a slow check doesn't block a reload of the host
+	el.statusMutex.RLock()
+	for name, check := range el.status {
+		checks[name] = check
+	}
+	for name, counter := range el.counters {
+		counters[name] = counter
+	}
+	el.statusMutex.RUnlock()
+
+	for name := range checks {
+		nameList = append(nameList, name)
+	}
+	sort.Strings(nameList)
+
+	for _, name := range nameList {
+		status := statusJSonOut{Name: name, Success: true}
+
+		err := checks[name]()
+		if err != nil {
+			status.Success = false
+			status.Error = err.Error()
+			httpStatus = http.StatusServiceUnavailable
+		}
+
+		statusList = append(statusList, status)
+	}
+
+	nameList = nil
+	for name := range counters {
+		nameList = append(nameList, name)
+	}
+	sort.Strings(nameList)
+
+	for _, name := range nameList {
+		statusList = append(statusList, statusJSonOut{Name: name, Success: true, Counters: counters[name]()})
+	}
+
+	// a failed check always has a status in the list, so ToOutput() doesn't write the header again
+	if httpStatus != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json;")
+		w.WriteHeader(httpStatus)
+	}
+
+	output.ToOutput(len(statusList), nil, statusList, w)
+}
+
+// first global address of the interfaces that are up. IPv4 addresses are preferred over IPv6 addresses
 func (el *HttpServer) externalIP() (string, error) {
 	iFaces, err := net.Interfaces()
 	if err != nil {
 		return "", err
 	}
+
+	return globalAddress(iFaces)
+}
+
+// first global address of the interfaces that are up and aren't loopback. IPv4 addresses are preferred over IPv6
+// addresses
+func globalAddress(iFaces []net.Interface) (string, error) {
+	var ipv6 net.IP
+
 	for _, iFace := range iFaces {
 		if iFace.Flags&net.FlagUp == 0 {
 			continue
@@ -807,17 +1270,23 @@ func (el *HttpServer) externalIP() (string, error) {
 			case *net.IPAddr:
 				ip = v.IP
 			}
-			if ip == nil || ip.IsLoopback() {
+			if ip == nil || ip.IsLoopback() || !ip.IsGlobalUnicast() {
 				continue
 			}
-			ip = ip.To4()
-			if ip == nil {
-				continue // not an ipv4 address
+			if ip.To4() != nil {
+				return ip.To4().String(), nil
+			}
+			if ipv6 == nil {
+				ipv6 = ip
 			}
-			return ip.String(), nil
 		}
 	}
-	return "", errors.New("internet connection not found")
+
+	if ipv6 != nil {
+		return ipv6.String(), nil
+	}
+
+	return "", errors.New("global address not found")
 }
 
 var PluginData HttpServer
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// networks of the proxies trusted to send the client address in the Forwarded or X-Forwarded-For headers
+type trustedProxies []*net.IPNet
+
+func newTrustedProxies(cidrList []string) (trustedProxies, error) {
+	var proxies trustedProxies
+
+	for _, cidr := range cidrList {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+func (el trustedProxies) contains(ip net.IP) bool {
+	for _, network := range el {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ip of an address in the formats "192.168.0.1", "192.168.0.1:80", "2001:db8::1", "[2001:db8::1]:80" or
+// "fe80::1%eth0". IPv4 addresses mapped in IPv6 are returned as IPv4
+func parseAddressIP(address string) net.IP {
+	address = strings.Trim(strings.TrimSpace(address), `"`)
+
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+
+	// zone of link local addresses
+	if k := strings.Index(address, "%"); k != -1 {
+		address = address[:k]
+	}
+
+	ip := net.ParseIP(address)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
+
+// addresses of the Forwarded header, RFC 7239, or of the X-Forwarded-For header, from the client to the last proxy
+func forwardedAddresses(r *http.Request) []string {
+	var addresses []string
+
+	for _, header := range r.Header["Forwarded"] {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					addresses = append(addresses, pair[4:])
+				}
+			}
+		}
+	}
+
+	if len(addresses) != 0 {
+		return addresses
+	}
+
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, address := range strings.Split(header, ",") {
+			addresses = append(addresses, strings.TrimSpace(address))
+		}
+	}
+
+	return addresses
+}
+
+// ip of the client. Requests of trusted proxies are followed back to the first address that isn't a trusted proxy.
+// Nil when the address can't be found, ex.: "for=unknown"
+func (el *HttpServer) clientIP(r *http.Request) net.IP {
+	ip := parseAddressIP(r.RemoteAddr)
+	if ip == nil || !el.trustedProxies.contains(ip) {
+		return ip
+	}
+
+	addresses := forwardedAddresses(r)
+	for k := len(addresses) - 1; k >= 0; k-- {
+		ip = parseAddressIP(addresses[k])
+		if ip == nil || !el.trustedProxies.contains(ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
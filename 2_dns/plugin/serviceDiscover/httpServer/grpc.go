@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"gRPC/2_dns/plugin/serviceDiscover/registry"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// grpc api configuration
+//
+//   port: port of the grpc listener, bound to the address of the http listener. With the tls section, the grpc
+//         listener uses the same certificate and client certificate verification
+type grpcConfig struct {
+	Port int
+}
+
+// grpc api of the registry, see plugin/serviceDiscover/registry/registry.proto. It shares the data plugin, the auth
+// policy, the audit log and the watch of the http api
+type grpcRegistry struct {
+	server *HttpServer
+}
+
+// starts the grpc listener. Errors of the listener after the start go to the plugin log
+func (el *HttpServer) listenGrpc() error {
+	var options []grpc.ServerOption
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(el.address, strconv.Itoa(el.grpcPort)))
+	if err != nil {
+		return err
+	}
+
+	if el.tlsServer != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(el.tlsServer)))
+	}
+
+	server := grpc.NewServer(options...)
+	registry.RegisterRegistryServer(server, &grpcRegistry{server: el})
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			el.handleError(err)
+		}
+	}()
+
+	return nil
+}
+
+// grpc code of the http status of the error
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	converted, ok := err.(*statusError)
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	switch converted.status {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, converted.message)
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, converted.message)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, converted.message)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, converted.message)
+	case http.StatusConflict:
+		return status.Error(codes.FailedPrecondition, converted.message)
+	}
+
+	return status.Error(codes.Internal, converted.message)
+}
+
+// http status of the error, for the audit log
+func auditStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	if converted, ok := err.(*statusError); ok {
+		return converted.status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// address of the client of the call. nil when the peer isn't in the context
+func grpcClientIP(ctx context.Context) net.IP {
+	if client, ok := peer.FromContext(ctx); ok && client.Addr != nil {
+		return parseAddressIP(client.Addr.String())
+	}
+
+	return nil
+}
+
+// identity of the "authorization: Bearer {token}" metadata or of the client certificate, when the auth section is
+// set. Returns a status error for unknown clients and for names out of the patterns of the identity
+func (el *grpcRegistry) authorize(ctx context.Context, serviceName string) (*authIdentity, error) {
+	var token string
+	var certificate *x509.Certificate
+
+	if el.server.auth == nil {
+		return nil, nil
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) != 0 {
+			token = strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+		}
+	}
+
+	if client, ok := peer.FromContext(ctx); ok {
+		if info, ok := client.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) != 0 {
+			certificate = info.State.PeerCertificates[0]
+		}
+	}
+
+	identity := el.server.auth.identity(token, certificate)
+
+	switch el.server.auth.allowed(identity, serviceName) {
+	case errAuthUnauthorized:
+		return nil, newStatusError(http.StatusUnauthorized, errAuthUnauthorized.Error())
+	case errAuthForbidden:
+		return identity, newStatusError(http.StatusForbidden, "identity "+identity.Name+" may not change "+serviceName)
+	}
+
+	return identity, nil
+}
+
+// register and deregister need an identity allowed to change the name, when the auth section is set, and are written
+// to the audit log, like the changes of the http api
+func (el *grpcRegistry) mutation(ctx context.Context, method, serviceName string, change func() error) error {
+	var entry = auditEntry{Method: method, Path: "/service/" + serviceName}
+
+	if client, ok := peer.FromContext(ctx); ok && client.Addr != nil {
+		entry.Remote = client.Addr.String()
+	}
+
+	identity, err := el.authorize(ctx, serviceName)
+	if identity != nil {
+		entry.Identity = identity.Name
+	}
+
+	if err == nil {
+		err = change()
+	}
+
+	entry.Status = auditStatus(err)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	el.server.audit.Write(entry)
+
+	return grpcError(err)
+}
+
+func grpcInstances(records []serviceRecord) []*registry.Instance {
+	var instances = make([]*registry.Instance, 0, len(records))
+
+	for _, record := range records {
+		instances = append(instances, &registry.Instance{
+			Priority: int32(record.Priority),
+			Weight:   int32(record.Weight),
+			Port:     int32(record.Port),
+			Target:   record.Target,
+			Metadata: record.Metadata,
+			View:     record.View,
+		})
+	}
+
+	return instances
+}
+
+func grpcService(item serviceListJSonOut) *registry.Service {
+	return &registry.Service{Name: item.Name, Alias: item.Alias, Instances: grpcInstances(item.Instances)}
+}
+
+func (el *grpcRegistry) checkData() error {
+	if el.server.dataGet == nil || el.server.dataPut == nil || el.server.dataDelete == nil || el.server.dataGetByPrefix == nil {
+		return errors.New("ben burkert dns plugin config error. please, define the getData, putData, deleteData and getByPrefixData functions")
+	}
+
+	return nil
+}
+
+// registers an instance of the service. A blank target is the address of the client
+func (el *grpcRegistry) Register(ctx context.Context, in *registry.RegisterRequest) (*registry.InstanceList, error) {
+	var records []serviceRecord
+
+	if err := el.checkData(); err != nil {
+		return nil, grpcError(err)
+	}
+
+	if in.Name == "" || strings.Contains(in.Name, "/") {
+		return nil, status.Error(codes.InvalidArgument, "register data error. service name must be a non empty string without '/'")
+	}
+
+	err := el.mutation(ctx, "/registry.Registry/Register", in.Name, func() error {
+		var err error
+		var inData = service{Port: int(in.Port), Target: in.Target, Metadata: in.Metadata, View: in.View}
+
+		records, err = el.server.registerInstance(in.Name, inData, grpcClientIP(ctx))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &registry.InstanceList{Instances: grpcInstances(records)}, nil
+}
+
+// deregisters the instance of the target and port
+func (el *grpcRegistry) Deregister(ctx context.Context, in *registry.DeregisterRequest) (*registry.InstanceList, error) {
+	var records []serviceRecord
+
+	if err := el.checkData(); err != nil {
+		return nil, grpcError(err)
+	}
+
+	err := el.mutation(ctx, "/registry.Registry/Deregister", in.Name, func() error {
+		var err error
+
+		records, err = el.server.deregisterInstance(in.Name, service{Port: int(in.Port), Target: in.Target})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &registry.InstanceList{Instances: grpcInstances(records)}, nil
+}
+
+// lists the services, like [GET] /service
+func (el *grpcRegistry) List(ctx context.Context, in *registry.ListRequest) (*registry.ServiceList, error) {
+	var out = &registry.ServiceList{}
+	var query = serviceListQuery{
+		prefix: in.Prefix,
+		target: in.Target,
+		port:   int(in.Port),
+		offset: int(in.Offset),
+		limit:  int(in.Limit),
+	}
+
+	if err := el.checkData(); err != nil {
+		return nil, grpcError(err)
+	}
+
+	if query.limit == 0 {
+		query.limit = kServiceListDefaultLimit
+	}
+
+	if err := query.check(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	list, total, err := el.server.listServices(query)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	for _, item := range list {
+		out.Services = append(out.Services, grpcService(item))
+	}
+	out.TotalCount = int32(total)
+
+	return out, nil
+}
+
+// streams the services and then each change, like [GET] /watch
+func (el *grpcRegistry) Watch(in *registry.WatchRequest, stream registry.Registry_WatchServer) error {
+	if err := el.checkData(); err != nil {
+		return grpcError(err)
+	}
+
+	// subscribed before the snapshot, so no change is lost between them
+	subscriber := el.server.watch.Subscribe(in.Name, in.Prefix)
+	defer el.server.watch.Unsubscribe(subscriber)
+
+	snapshot, err := el.server.watchSnapshot(in.Name, in.Prefix)
+	if err != nil {
+		return grpcError(err)
+	}
+
+	for _, item := range snapshot {
+		if err = stream.Send(&registry.WatchEvent{Type: registry.WatchEvent_PUT, Service: grpcService(item)}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case event, ok := <-subscriber.events:
+			// dropped by the hub
+			if !ok {
+				return status.Error(codes.Unavailable, "watch dropped, the client didn't read the events. please, watch again")
+			}
+
+			eventType := registry.WatchEvent_PUT
+			if event.event == "delete" {
+				eventType = registry.WatchEvent_DELETE
+			}
+
+			if err = stream.Send(&registry.WatchEvent{Type: eventType, Service: grpcService(event.data)}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"net/http"
+)
+
+// OpenAPI 3 document of the http api, served by /openapi.json. The Go client is in plugin/serviceDiscover/httpClient
+const kOpenApiDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Ben Burkert DNS compatible http server",
+    "description": "Registers the instances of the services served as SRV records by the DNS plugin. POST, PUT and DELETE need a bearer token or a client certificate of the policy file when the auth section is set.",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/service": {
+      "get": {
+        "summary": "List the services, sorted by name",
+        "operationId": "List",
+        "parameters": [
+          { "name": "prefix", "in": "query", "schema": { "type": "string" } },
+          { "name": "target", "in": "query", "schema": { "type": "string" }, "description": "only instances of the target" },
+          { "name": "port", "in": "query", "schema": { "type": "integer" }, "description": "only instances of the port" },
+          { "name": "offset", "in": "query", "schema": { "type": "integer", "minimum": 0, "default": 0 } },
+          { "name": "limit", "in": "query", "schema": { "type": "integer", "minimum": 1, "maximum": 1000, "default": 100 } }
+        ],
+        "responses": {
+          "200": { "description": "services found before the offset and the limit in TotalCount", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/ServiceList" } } } },
+          "400": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/service/{name}": {
+      "parameters": [ { "$ref": "#/components/parameters/Name" } ],
+      "get": {
+        "summary": "Instances of the service",
+        "operationId": "Get",
+        "responses": {
+          "200": { "$ref": "#/components/responses/Instances" },
+          "404": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "post": {
+        "summary": "Register an instance, or update the metadata and the view of a registered instance",
+        "operationId": "Register",
+        "security": [ {}, { "bearer": [] } ],
+        "requestBody": { "required": true, "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Register" } } } },
+        "responses": {
+          "200": { "$ref": "#/components/responses/Instances" },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "403": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "put": {
+        "summary": "Register an instance or, with a list, replace the instance set in one write. An empty list deregisters the service",
+        "operationId": "Replace",
+        "security": [ {}, { "bearer": [] } ],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "oneOf": [ { "$ref": "#/components/schemas/Register" }, { "type": "array", "items": { "$ref": "#/components/schemas/Register" } } ] } } }
+        },
+        "responses": {
+          "200": { "$ref": "#/components/responses/Instances" },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "403": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "delete": {
+        "summary": "Deregister the instance of the target and port. The service is deleted with its last instance",
+        "operationId": "Deregister",
+        "security": [ {}, { "bearer": [] } ],
+        "requestBody": { "required": true, "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Register" } } } },
+        "responses": {
+          "200": { "$ref": "#/components/responses/Instances" },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "403": { "$ref": "#/components/responses/Error" },
+          "404": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/batch": {
+      "post": {
+        "summary": "Replace the instance sets of many services in one transaction. An empty list deregisters the service",
+        "operationId": "Batch",
+        "security": [ {}, { "bearer": [] } ],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "type": "array", "minItems": 1, "maxItems": 1000, "items": { "$ref": "#/components/schemas/BatchItem" } } } }
+        },
+        "responses": {
+          "200": { "description": "services changed", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/ServiceList" } } } },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "403": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/alias/{name}": {
+      "parameters": [ { "$ref": "#/components/parameters/Name" } ],
+      "get": {
+        "summary": "Target of the alias",
+        "operationId": "GetAlias",
+        "responses": {
+          "200": { "$ref": "#/components/responses/Alias" },
+          "404": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "post": {
+        "summary": "Set the alias, served as CNAME of the target",
+        "operationId": "SetAlias",
+        "security": [ {}, { "bearer": [] } ],
+        "requestBody": { "required": true, "content": { "application/json": { "schema": { "$ref": "#/components/schemas/AliasRequest" } } } },
+        "responses": {
+          "200": { "$ref": "#/components/responses/Alias" },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "403": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "put": {
+        "summary": "Set the alias, served as CNAME of the target",
+        "operationId": "PutAlias",
+        "security": [ {}, { "bearer": [] } ],
+        "requestBody": { "required": true, "content": { "application/json": { "schema": { "$ref": "#/components/schemas/AliasRequest" } } } },
+        "responses": {
+          "200": { "$ref": "#/components/responses/Alias" },
+          "400": { "$ref": "#/components/responses/Error" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "403": { "$ref": "#/components/responses/Error" },
+          "409": { "$ref": "#/components/responses/Error" }
+        }
+      },
+      "delete": {
+        "summary": "Delete the alias",
+        "operationId": "DeleteAlias",
+        "security": [ {}, { "bearer": [] } ],
+        "responses": {
+          "200": { "$ref": "#/components/responses/Alias" },
+          "401": { "$ref": "#/components/responses/Error" },
+          "403": { "$ref": "#/components/responses/Error" },
+          "404": { "$ref": "#/components/responses/Error" }
+        }
+      }
+    },
+    "/watch": {
+      "get": {
+        "summary": "Stream of the services of the prefix and of their changes",
+        "operationId": "WatchPrefix",
+        "parameters": [ { "name": "prefix", "in": "query", "schema": { "type": "string" } } ],
+        "responses": { "200": { "$ref": "#/components/responses/Watch" } }
+      }
+    },
+    "/watch/{name}": {
+      "parameters": [ { "$ref": "#/components/parameters/Name" } ],
+      "get": {
+        "summary": "Stream of the service and of its changes",
+        "operationId": "Watch",
+        "responses": { "200": { "$ref": "#/components/responses/Watch" } }
+      }
+    },
+    "/status": {
+      "get": {
+        "summary": "Checks set by the host, like the readiness of the DNS plugin",
+        "operationId": "Status",
+        "responses": {
+          "200": { "$ref": "#/components/responses/Status" },
+          "503": { "$ref": "#/components/responses/Status" }
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "operationId": "OpenApi",
+        "responses": { "200": { "description": "OpenAPI 3 document", "content": { "application/json": {} } } }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": { "type": "http", "scheme": "bearer", "description": "token of an identity of the policy file. Identities without token are found by the client certificate" }
+    },
+    "parameters": {
+      "Name": { "name": "name", "in": "path", "required": true, "schema": { "type": "string" } }
+    },
+    "responses": {
+      "Error": { "description": "error, with the message in Meta.Error", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/Output" } } } },
+      "Instances": { "description": "instances of the service", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/InstanceList" } } } },
+      "Alias": { "description": "target of the alias", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/AliasList" } } } },
+      "Status": { "description": "result of each check", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/StatusList" } } } },
+      "Watch": {
+        "description": "server-sent events. The event is put or delete and the data is a Service, deleted services have the name only",
+        "content": { "text/event-stream": { "schema": { "type": "string" } } }
+      }
+    },
+    "schemas": {
+      "Meta": {
+        "type": "object",
+        "properties": { "TotalCount": { "type": "integer" }, "Success": { "type": "boolean" }, "Error": { "type": "string" } }
+      },
+      "Output": {
+        "type": "object",
+        "properties": { "Meta": { "$ref": "#/components/schemas/Meta" }, "Objects": { "type": "array", "items": {} } }
+      },
+      "Register": {
+        "type": "object",
+        "properties": {
+          "port": { "type": "integer" },
+          "target": { "type": "string", "description": "name or address ended in point. The address of the client when blank" },
+          "metadata": { "type": "object", "additionalProperties": { "type": "string" }, "description": "served as DNS-SD TXT record" },
+          "view": { "type": "string" }
+        }
+      },
+      "Instance": {
+        "type": "object",
+        "properties": {
+          "Priority": { "type": "integer" },
+          "Weight": { "type": "integer" },
+          "Port": { "type": "integer" },
+          "Target": { "type": "string" },
+          "Metadata": { "type": "object", "additionalProperties": { "type": "string" } },
+          "View": { "type": "string" }
+        }
+      },
+      "InstanceList": {
+        "type": "object",
+        "properties": { "Meta": { "$ref": "#/components/schemas/Meta" }, "Objects": { "type": "array", "items": { "$ref": "#/components/schemas/Instance" } } }
+      },
+      "Service": {
+        "type": "object",
+        "properties": {
+          "Name": { "type": "string" },
+          "Alias": { "type": "string" },
+          "Instances": { "type": "array", "items": { "$ref": "#/components/schemas/Instance" } }
+        }
+      },
+      "ServiceList": {
+        "type": "object",
+        "properties": { "Meta": { "$ref": "#/components/schemas/Meta" }, "Objects": { "type": "array", "items": { "$ref": "#/components/schemas/Service" } } }
+      },
+      "BatchItem": {
+        "type": "object",
+        "required": [ "name" ],
+        "properties": { "name": { "type": "string" }, "instances": { "type": "array", "items": { "$ref": "#/components/schemas/Register" } } }
+      },
+      "AliasRequest": {
+        "type": "object",
+        "required": [ "target" ],
+        "properties": { "target": { "type": "string", "description": "service name, or a name ended in point" } }
+      },
+      "AliasList": {
+        "type": "object",
+        "properties": {
+          "Meta": { "$ref": "#/components/schemas/Meta" },
+          "Objects": { "type": "array", "items": { "type": "object", "properties": { "Alias": { "type": "string" } } } }
+        }
+      },
+      "StatusList": {
+        "type": "object",
+        "properties": {
+          "Meta": { "$ref": "#/components/schemas/Meta" },
+          "Objects": {
+            "type": "array",
+            "items": { "type": "object", "properties": { "Name": { "type": "string" }, "Success": { "type": "boolean" }, "Error": { "type": "string" }, "Counters": { "type": "object", "additionalProperties": { "type": "integer" } } } }
+          }
+        }
+      }
+    }
+  }
+}
+`
+
+// http get method function
+// this method serves the OpenAPI document of the api
+//
+//   [GET] localhost:8080/openapi.json
+func (el *HttpServer) handleGetOpenApi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write([]byte(kOpenApiDocument)); err != nil {
+		el.handleError(err)
+	}
+}
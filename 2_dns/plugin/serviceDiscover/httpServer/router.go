@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// error with the http status code written by JSonOut.ToOutput(). Other errors are written as 500
+type statusError struct {
+	status  int
+	message string
+}
+
+func (el *statusError) Error() string {
+	return el.message
+}
+
+func newStatusError(status int, message string) error {
+	return &statusError{status: status, message: message}
+}
+
+// the list of endpoints, made once by Connect()
+func (el *HttpServer) routes() handleList {
+	return handleList{
+		{Method: http.MethodPost, Type: "service", Name: true, Func: el.handlePutService},
+		{Method: http.MethodPut, Type: "service", Name: true, Func: el.handlePutService},
+		{Method: http.MethodGet, Type: "service", Name: true, Func: el.handleGetService},
+		{Method: http.MethodGet, Type: "service", Name: false, Func: el.handleListService},
+		{Method: http.MethodDelete, Type: "service", Name: true, Func: el.handleDeleteService},
+		{Method: http.MethodPost, Type: "batch", Name: false, Func: el.handleBatch},
+		{Method: http.MethodGet, Type: "status", Name: false, Func: el.handleGetStatus},
+		{Method: http.MethodGet, Type: "openapi.json", Name: false, Func: el.handleGetOpenApi},
+		{Method: http.MethodGet, Type: "watch", Name: true, Func: el.handleWatch},
+		{Method: http.MethodGet, Type: "watch", Name: false, Func: el.handleWatch},
+		{Method: http.MethodPost, Type: "alias", Name: true, Func: el.handlePutAlias},
+		{Method: http.MethodPut, Type: "alias", Name: true, Func: el.handlePutAlias},
+		{Method: http.MethodGet, Type: "alias", Name: true, Func: el.handleGetAlias},
+		{Method: http.MethodDelete, Type: "alias", Name: true, Func: el.handleDeleteAlias},
+	}
+}
+
+// type and name of the url path /{type}/{name}. ok is false for paths with more elements
+func splitPath(path string) (endpointType, name string, ok bool) {
+	elements := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch len(elements) {
+	case 1:
+		return elements[0], "", true
+	case 2:
+		return elements[0], elements[1], elements[1] != ""
+	}
+
+	return "", "", false
+}
+
+// name of the url path /{type}/{name}
+func (el *HttpServer) pathName(r *http.Request) string {
+	_, name, _ := splitPath(r.URL.Path)
+	return name
+}
+
+// http handle function. Unknown paths are answered with 404 and known paths with other methods with 405
+func (el *HttpServer) handleFunc(w http.ResponseWriter, r *http.Request) {
+	var output JSonOut
+	var allow []string
+
+	endpointType, name, ok := splitPath(r.URL.Path)
+	if !ok {
+		output.ToOutput(0, newStatusError(http.StatusNotFound, "path "+r.URL.Path+" not found"), nil, w)
+		return
+	}
+
+	for _, handleData := range el.handleList {
+		if handleData.Type != endpointType || handleData.Name != (name != "") {
+			continue
+		}
+
+		if handleData.Method == r.Method && r.Method == http.MethodGet {
+			handleData.Func(w, r)
+			return
+		}
+
+		if handleData.Method == r.Method {
+			el.handleMutation(handleData, w, r, name)
+			return
+		}
+
+		allow = append(allow, handleData.Method)
+	}
+
+	if len(allow) == 0 {
+		output.ToOutput(0, newStatusError(http.StatusNotFound, "path "+r.URL.Path+" not found"), nil, w)
+		return
+	}
+
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	output.ToOutput(0, newStatusError(http.StatusMethodNotAllowed, "method "+r.Method+" not allowed. please, use "+strings.Join(allow, ", ")), nil, w)
+}
+
+// audit entry of the request, without the status
+func (el *HttpServer) newAuditEntry(r *http.Request) auditEntry {
+	var entry = auditEntry{Remote: r.RemoteAddr, Method: r.Method, Path: r.URL.Path}
+
+	// client behind the trusted proxies
+	if len(el.trustedProxies) != 0 {
+		if ip := el.clientIP(r); ip != nil {
+			entry.Client = ip.String()
+		}
+	}
+
+	if el.auth != nil {
+		if identity := el.auth.Identity(r); identity != nil {
+			entry.Identity = identity.Name
+		}
+	}
+
+	return entry
+}
+
+// register, deregister and alias changes need an identity allowed to change the name, when the auth section is set,
+// and are written to the audit log. Paths without name, like the batch, need a known identity and authorize each name
+// of the body
+func (el *HttpServer) handleMutation(handleData handle, w http.ResponseWriter, r *http.Request, name string) {
+	var output JSonOut
+	var recorder = &statusRecorder{ResponseWriter: w}
+	var entry = el.newAuditEntry(r)
+
+	if el.auth != nil {
+		var err error
+		var identity = el.auth.Identity(r)
+
+		if identity == nil {
+			err = errAuthUnauthorized
+		} else if name != "" {
+			_, err = el.auth.Authorize(r, name)
+		}
+
+		switch err {
+		case errAuthUnauthorized:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="service discover"`)
+			output.ToOutput(0, newStatusError(http.StatusUnauthorized, err.Error()), nil, recorder)
+		case errAuthForbidden:
+			err = errors.New("identity " + identity.Name + " may not change " + name)
+			output.ToOutput(0, newStatusError(http.StatusForbidden, err.Error()), nil, recorder)
+		}
+
+		if err != nil {
+			entry.Status = recorder.status
+			entry.Error = err.Error()
+			el.audit.Write(entry)
+			return
+		}
+	}
+
+	handleData.Func(recorder, r)
+
+	entry.Status = recorder.status
+	if len(recorder.changes) == 0 {
+		el.audit.Write(entry)
+		return
+	}
+
+	for _, change := range recorder.changes {
+		entry.Method = change.Method
+		entry.Path = change.Path
+		entry.Error = change.Error
+		el.audit.Write(entry)
+	}
+}
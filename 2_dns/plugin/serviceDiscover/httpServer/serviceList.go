@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/helmutkemper/communsTypesForGolangPlugin"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	kServiceListDefaultLimit = 100
+	kServiceListMaxLimit     = 1000
+)
+
+// data output format of the service list, one object for each service or alias
+type serviceListJSonOut struct {
+	Name      string
+	Alias     string          `json:",omitempty"`
+	Instances []serviceRecord `json:",omitempty"`
+}
+
+// filters and page of the service list, from the url query
+type serviceListQuery struct {
+	prefix string
+	target string
+	port   int
+	offset int
+	limit  int
+}
+
+func newServiceListQuery(r *http.Request) (serviceListQuery, error) {
+	var err error
+	var values = r.URL.Query()
+	var query = serviceListQuery{
+		prefix: values.Get("prefix"),
+		target: values.Get("target"),
+		limit:  kServiceListDefaultLimit,
+	}
+
+	for name, value := range map[string]*int{"port": &query.port, "offset": &query.offset, "limit": &query.limit} {
+		if values.Get(name) == "" {
+			continue
+		}
+
+		*value, err = strconv.Atoi(values.Get(name))
+		if err != nil || *value < 0 {
+			return query, errors.New("query " + name + " must be a positive number")
+		}
+	}
+
+	return query, query.check()
+}
+
+// checks the limit and ends the target in point, like the saved targets
+func (el *serviceListQuery) check() error {
+	if el.target != "" && !strings.HasSuffix(el.target, ".") {
+		el.target += "."
+	}
+
+	if el.port < 0 || el.offset < 0 {
+		return errors.New("query port and offset must be positive numbers")
+	}
+
+	if el.limit <= 0 || el.limit > kServiceListMaxLimit {
+		return errors.New("query limit must be between 1 and " + strconv.Itoa(kServiceListMaxLimit))
+	}
+
+	return nil
+}
+
+// true when the query filters the instances
+func (el *serviceListQuery) filterInstances() bool {
+	return el.target != "" || el.port != 0
+}
+
+func (el *serviceListQuery) match(record serviceRecord) bool {
+	return (el.target == "" || record.Target == el.target) && (el.port == 0 || record.Port == el.port)
+}
+
+// http get method function
+// this method lists the registered services, sorted by name. Services without instances of the target or port of
+// the query aren't listed, neither are the aliases.
+//
+//   url query, all optional:
+//   prefix: start of the service name. ex.: "postgres-"
+//   target: target of an instance. ex.: "192.168.10.1."
+//   port:   port of an instance. ex.: 8080
+//   offset: number of services skipped. default 0
+//   limit:  largest number of services in the answer, up to 1000. default 100
+//
+//   [GET] localhost:8080/service?prefix=node&offset=0&limit=100
+//
+//   JSon output format, TotalCount is the number of services found before the offset and the limit:
+//   {
+//     "Meta": {
+//         "TotalCount": 2,
+//         "Success": true,
+//         "Error": ""
+//     },
+//     "Objects": [
+//         {
+//             "Name": "db",
+//             "Alias": "postgres-primary"
+//         },
+//         {
+//             "Name": "node",
+//             "Instances": [
+//                 {
+//                     "Priority": 10,
+//                     "Weight": 10,
+//                     "Port": 8080,
+//                     "Target": "192.168.10.1."
+//                 }
+//             ]
+//         }
+//     ]
+//   }
+func (el *HttpServer) handleListService(w http.ResponseWriter, r *http.Request) {
+	var output JSonOut
+
+	if el.dataGetByPrefix == nil {
+		output.ToOutput(0, errors.New("ben burkert dns plugin config error. please, define a getByPrefixData function"), nil, w)
+		return
+	}
+
+	query, err := newServiceListQuery(r)
+	if err != nil {
+		output.ToOutput(0, newStatusError(http.StatusBadRequest, err.Error()), nil, w)
+		return
+	}
+
+	list, total, err := el.listServices(query)
+	if err != nil {
+		output.ToOutput(0, err, nil, w)
+		return
+	}
+
+	output.ToOutput(total, nil, list, w)
+}
+
+// services and aliases of the query, sorted by name, and the number of services found before the offset and the limit
+func (el *HttpServer) listServices(query serviceListQuery) ([]serviceListJSonOut, int, error) {
+	var err error
+	var dataFromDataSource []communsTypes.KeyValueType
+	var list = make([]serviceListJSonOut, 0)
+
+	err, _, dataFromDataSource = el.dataGetByPrefix([]byte(el.servicePrefix + query.prefix))
+	if err != nil {
+		el.handleError(err)
+		return nil, 0, errors.New("internal server error")
+	}
+
+	for _, data := range dataFromDataSource {
+		var records []serviceRecord
+		var saved serviceAlias
+		var item = serviceListJSonOut{Name: strings.TrimPrefix(string(data.K), el.servicePrefix)}
+
+		// services are saved as a list and aliases as an object
+		if json.Unmarshal(data.V, &records) != nil {
+			if json.Unmarshal(data.V, &saved) != nil || saved.Alias == "" || query.filterInstances() {
+				continue
+			}
+
+			item.Alias = saved.Alias
+			list = append(list, item)
+			continue
+		}
+
+		for _, record := range records {
+			if query.match(record) {
+				item.Instances = append(item.Instances, record)
+			}
+		}
+
+		if len(item.Instances) != 0 {
+			list = append(list, item)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	total := len(list)
+	if query.offset > total {
+		query.offset = total
+	}
+
+	list = list[query.offset:]
+	if len(list) > query.limit {
+		list = list[:query.limit]
+	}
+
+	return list, total, nil
+}
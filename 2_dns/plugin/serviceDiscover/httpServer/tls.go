@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// https configuration
+//
+//   certFile:         certificate of the server, in PEM format
+//   keyFile:          private key of the certificate, in PEM format
+//   caFile:           optional. CA of the client certificates and of the server certificate, used by SelfRegister()
+//                     and Register(). Without it, the system CAs are used. Client certificates signed by it identify
+//                     the client to the auth policy
+//   verifyClientCert: refuse connections without a client certificate signed by caFile
+//   serverName:       optional. name checked in the server certificate by SelfRegister() and Register(). Without it,
+//                     the address of the url is checked
+type tlsConfig struct {
+	CertFile         string
+	KeyFile          string
+	CaFile           string
+	VerifyClientCert bool
+	ServerName       string
+}
+
+// tls configuration of the listener and of the client used by SelfRegister() and Register(). The client presents the
+// server certificate, so it passes the client certificate verification of the listener too
+func newTlsConfig(config tlsConfig) (server *tls.Config, client *tls.Config, err error) {
+	var certificate tls.Certificate
+	var pool *x509.CertPool
+
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, nil, errors.New("tls certFile and keyFile must be non empty strings")
+	}
+
+	certificate, err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.CaFile != "" {
+		var pem []byte
+
+		pem, err = ioutil.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, errors.New("tls caFile " + config.CaFile + " has no PEM certificate")
+		}
+	}
+
+	if config.VerifyClientCert && pool == nil {
+		return nil, nil, errors.New("tls verifyClientCert needs a caFile")
+	}
+
+	server = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if pool != nil {
+		server.ClientCAs = pool
+		server.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if config.VerifyClientCert {
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	client = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      pool,
+		ServerName:   config.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return server, client, nil
+}
+
+// client of SelfRegister() and Register()
+func newHttpClient(config *tls.Config) *http.Client {
+	var transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     config,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/helmutkemper/communsTypesForGolangPlugin"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kWatchBufferLength         = 64
+	kWatchKeepAliveMillisecond = 30000
+)
+
+// event sent to the watch stream, in the format of the service list
+type watchEvent struct {
+	event string
+	data  serviceListJSonOut
+}
+
+// one client of the watch stream. Names are matched by the exact name or by the prefix
+type watchSubscriber struct {
+	name   string
+	prefix string
+	events chan watchEvent
+}
+
+func (el *watchSubscriber) match(name string) bool {
+	if el.name != "" {
+		return name == el.name
+	}
+
+	return strings.HasPrefix(name, el.prefix)
+}
+
+// watchHub sends the changes of the data plugin to the clients of the watch stream
+type watchHub struct {
+	sync.Mutex
+	subscribers map[*watchSubscriber]bool
+}
+
+func (el *watchHub) Subscribe(name, prefix string) *watchSubscriber {
+	var subscriber = &watchSubscriber{name: name, prefix: prefix, events: make(chan watchEvent, kWatchBufferLength)}
+
+	el.Lock()
+	defer el.Unlock()
+
+	if el.subscribers == nil {
+		el.subscribers = make(map[*watchSubscriber]bool)
+	}
+	el.subscribers[subscriber] = true
+
+	return subscriber
+}
+
+func (el *watchHub) Unsubscribe(subscriber *watchSubscriber) {
+	el.Lock()
+	defer el.Unlock()
+
+	if el.subscribers[subscriber] {
+		delete(el.subscribers, subscriber)
+		close(subscriber.events)
+	}
+}
+
+// send the event to the clients of the name. Clients that don't read their events are dropped, so they connect
+// again and get a new snapshot, instead of missing changes
+func (el *watchHub) Publish(event watchEvent) {
+	el.Lock()
+	defer el.Unlock()
+
+	for subscriber := range el.subscribers {
+		if !subscriber.match(event.data.Name) {
+			continue
+		}
+
+		select {
+		case subscriber.events <- event:
+		default:
+			delete(el.subscribers, subscriber)
+			close(subscriber.events)
+		}
+	}
+}
+
+// service or alias of a value saved in the data plugin
+func serviceListItem(name string, value []byte) (serviceListJSonOut, bool) {
+	var records []serviceRecord
+	var saved serviceAlias
+	var item = serviceListJSonOut{Name: name}
+
+	if json.Unmarshal(value, &records) == nil {
+		item.Instances = records
+		return item, true
+	}
+
+	if json.Unmarshal(value, &saved) == nil && saved.Alias != "" {
+		item.Alias = saved.Alias
+		return item, true
+	}
+
+	return item, false
+}
+
+// plugin receives the events of the watch of the data plugin. Events have the type "PUT" or "DELETE" in T
+func (el *HttpServer) Changed(events []communsTypes.KeyValueType) {
+	for _, event := range events {
+		if !strings.HasPrefix(string(event.K), el.servicePrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(string(event.K), el.servicePrefix)
+		item, ok := serviceListItem(name, event.V)
+
+		if string(event.T) == "DELETE" || !ok || (item.Alias == "" && len(item.Instances) == 0) {
+			el.watch.Publish(watchEvent{event: "delete", data: serviceListJSonOut{Name: name}})
+			continue
+		}
+
+		el.watch.Publish(watchEvent{event: "put", data: item})
+	}
+}
+
+// services of the name, or of the prefix when the name is blank, sent as the first events of the watch stream
+func (el *HttpServer) watchSnapshot(name, prefix string) ([]serviceListJSonOut, error) {
+	var err error
+	var found int
+	var dataFromDataSource []communsTypes.KeyValueType
+	var snapshot []serviceListJSonOut
+
+	if name != "" {
+		err, found, dataFromDataSource = el.dataGet([]byte(el.servicePrefix + name))
+	} else {
+		err, found, dataFromDataSource = el.dataGetByPrefix([]byte(el.servicePrefix + prefix))
+	}
+	if err != nil {
+		el.handleError(err)
+		return nil, errors.New("internal server error")
+	}
+
+	for k := 0; k < found && k < len(dataFromDataSource); k++ {
+		item, ok := serviceListItem(strings.TrimPrefix(string(dataFromDataSource[k].K), el.servicePrefix), dataFromDataSource[k].V)
+		if ok {
+			snapshot = append(snapshot, item)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// write one server-sent event
+func writeWatchEvent(w http.ResponseWriter, event watchEvent) error {
+	data, err := json.Marshal(&event.data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.event, data)
+	return err
+}
+
+// http get method function
+// this method streams the changes of a service, /watch/{name}, or of the services of a prefix, /watch?prefix={prefix},
+// as server-sent events. The first events are the current services, then each change is sent as it arrives from the
+// data plugin. Deleted services are sent with the name only.
+//
+//   [GET] localhost:8080/watch/node
+//   [GET] localhost:8080/watch?prefix=node
+//
+//   Stream format:
+//   event: put
+//   data: {"Name":"node","Instances":[{"Priority":10,"Weight":10,"Port":8080,"Target":"192.168.10.1."}]}
+//
+//   event: delete
+//   data: {"Name":"node"}
+func (el *HttpServer) handleWatch(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var output JSonOut
+	var snapshot []serviceListJSonOut
+
+	if el.dataGet == nil || el.dataGetByPrefix == nil {
+		output.ToOutput(0, errors.New("ben burkert dns plugin config error. please, define a getData and a getByPrefixData function"), nil, w)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		output.ToOutput(0, errors.New("streaming unsupported by the http server"), nil, w)
+		return
+	}
+
+	name := el.pathName(r)
+	prefix := r.URL.Query().Get("prefix")
+
+	// subscribed before the snapshot, so no change is lost between them
+	subscriber := el.watch.Subscribe(name, prefix)
+	defer el.watch.Unsubscribe(subscriber)
+
+	snapshot, err = el.watchSnapshot(name, prefix)
+	if err != nil {
+		output.ToOutput(0, err, nil, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for _, item := range snapshot {
+		if writeWatchEvent(w, watchEvent{event: "put", data: item}) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(kWatchKeepAliveMillisecond * time.Millisecond)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			if _, err = fmt.Fprint(w, ": keep alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case event, ok := <-subscriber.events:
+			// dropped by the hub
+			if !ok {
+				return
+			}
+
+			if writeWatchEvent(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
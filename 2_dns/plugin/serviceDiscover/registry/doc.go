@@ -0,0 +1,4 @@
+// Package registry is the gRPC api of the Ben Burkert DNS compatible http server plugin, generated from registry.proto
+package registry
+
+//go:generate protoc -I . --go_out=plugins=grpc:. registry.proto